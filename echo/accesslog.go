@@ -0,0 +1,316 @@
+package echo
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	accessLogOff      = "off"
+	accessLogCommon   = "common"
+	accessLogCombined = "combined"
+	accessLogJSON     = "json"
+
+	// accessLogTimeFormat is the timestamp format of the Common Log Format.
+	accessLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+	defaultAccessLogMaxSize    = 100 << 20
+	defaultAccessLogMaxBackups = 5
+)
+
+// accessLogSettings configures the access log.
+type accessLogSettings struct {
+	// Format is off, common, combined or json.
+	Format string
+
+	// File is the file the access log is written to, or empty for STDOUT.
+	File string
+
+	// MaxSize is the size in bytes at which File is rotated, or 0 to never
+	// rotate it.
+	MaxSize int64
+
+	// MaxBackups is the number of rotated files kept.
+	MaxBackups int
+
+	// Probes includes requests to the health, readiness and version
+	// endpoints, which are excluded by default.
+	Probes bool
+}
+
+// openAccessLog returns the writer for the configured access log.
+func openAccessLog(settings accessLogSettings) (io.Writer, error) {
+	if settings.File == "" {
+		return os.Stdout, nil
+	}
+
+	return openRotatingFile(settings.File, settings.MaxSize, settings.MaxBackups)
+}
+
+// connectionStats records what was exchanged over a WebSocket or SSE
+// connection, so that it can be included in the access log once the
+// connection closes.
+type connectionStats struct {
+	// Connection is set once the request becomes a long-lived connection.
+	Connection bool
+	Messages   int
+
+	// Probe is set for health, readiness and version requests, which are
+	// only logged if ACCESS_LOG_PROBES is set.
+	Probe bool
+}
+
+type connectionStatsKey struct{}
+
+// statsFromContext returns the stats of the connection being served, or nil
+// if the access log is disabled.
+func statsFromContext(ctx context.Context) *connectionStats {
+	stats, _ := ctx.Value(connectionStatsKey{}).(*connectionStats)
+	return stats
+}
+
+// recordConnection records the messages exchanged over a long-lived
+// connection, if the access log is enabled.
+func recordConnection(req *http.Request, messages int) {
+	if stats := statsFromContext(req.Context()); stats != nil {
+		stats.Connection = true
+		stats.Messages = messages
+	}
+}
+
+// recordProbe marks a request to a health, readiness or version endpoint, if
+// the access log is enabled.
+func recordProbe(req *http.Request) {
+	if stats := statsFromContext(req.Context()); stats != nil {
+		stats.Probe = true
+	}
+}
+
+// accessLogHandler writes a line to the access log for every request once it
+// has been served. WebSocket and SSE connections are logged when they close.
+type accessLogHandler struct {
+	next     http.Handler
+	settings accessLogSettings
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newAccessLogHandler(next http.Handler, w io.Writer, settings accessLogSettings) http.Handler {
+	return &accessLogHandler{next: next, settings: settings, w: w}
+}
+
+func (h *accessLogHandler) ServeHTTP(wr http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+
+	stats := &connectionStats{}
+	rec := &responseRecorder{ResponseWriter: wr}
+	h.next.ServeHTTP(rec, req.WithContext(context.WithValue(req.Context(), connectionStatsKey{}, stats)))
+
+	if stats.Probe && !h.settings.Probes {
+		return
+	}
+
+	line := formatAccessLog(h.settings.Format, req, rec.status, rec.bytes, start, time.Since(start), stats)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.w.Write(line) // nolint:errcheck
+}
+
+// accessLogEntry is an access log line in the JSON format.
+type accessLogEntry struct {
+	Time       string  `json:"time"`
+	RemoteAddr string  `json:"remote_addr"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+	Messages   *int    `json:"messages,omitempty"`
+}
+
+// formatAccessLog formats a single access log line, including the trailing
+// newline.
+//
+// The common and combined formats are followed by the duration in
+// microseconds, as logged by Apache's %D, and the number of messages
+// exchanged over a WebSocket or SSE connection, or "-" for other requests.
+func formatAccessLog(
+	format string,
+	req *http.Request,
+	status int,
+	bytes int64,
+	start time.Time,
+	duration time.Duration,
+	stats *connectionStats,
+) []byte {
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	host := clientIP(req)
+
+	if format == accessLogJSON {
+		entry := accessLogEntry{
+			Time:       start.Format(time.RFC3339Nano),
+			RemoteAddr: host,
+			Method:     req.Method,
+			Path:       req.URL.RequestURI(),
+			Proto:      req.Proto,
+			Status:     status,
+			Bytes:      bytes,
+			DurationMS: float64(duration.Microseconds()) / 1000,
+			Referer:    req.Referer(),
+			UserAgent:  req.UserAgent(),
+		}
+		if stats.Connection {
+			entry.Messages = &stats.Messages
+		}
+
+		data, _ := json.Marshal(entry)
+		return append(data, '\n')
+	}
+
+	user := "-"
+	if name, _, ok := req.BasicAuth(); ok && name != "" {
+		user = name
+	}
+
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s",
+		host,
+		user,
+		start.Format(accessLogTimeFormat),
+		req.Method+" "+req.URL.RequestURI()+" "+req.Proto,
+		status,
+		size,
+	)
+
+	if format == accessLogCombined {
+		line += fmt.Sprintf(" %q %q", orDash(req.Referer()), orDash(req.UserAgent()))
+	}
+
+	messages := "-"
+	if stats.Connection {
+		messages = strconv.Itoa(stats.Messages)
+	}
+
+	return []byte(fmt.Sprintf("%s %d %s\n", line, duration.Microseconds(), messages))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Hijack hijacks the underlying connection, as required to upgrade to a
+// WebSocket. The response is recorded as 101 Switching Protocols.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+
+	conn, rw, err := h.Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// rotatingFile is a log file that is renamed to path.1 once it reaches
+// maxSize bytes, with older files renamed to path.2 and so on, up to
+// maxBackups files. It is not safe for concurrent use.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p would take it over the
+// maximum size. A single write is never split across files.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file to the first backup and opens a new one. If the file
+// cannot be moved, logging continues in the existing file.
+func (f *rotatingFile) rotate() error {
+	f.file.Close() // nolint:errcheck
+
+	backup := func(n int) string {
+		return f.path + "." + strconv.Itoa(n)
+	}
+
+	if f.maxBackups > 0 {
+		os.Remove(backup(f.maxBackups)) // nolint:errcheck
+		for n := f.maxBackups - 1; n > 0; n-- {
+			os.Rename(backup(n), backup(n+1)) // nolint:errcheck
+		}
+		os.Rename(f.path, backup(1)) // nolint:errcheck
+	} else {
+		os.Remove(f.path) // nolint:errcheck
+	}
+
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,179 @@
+package echo
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// syncBuffer is a buffer that is safe to read while a server writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitForLine waits for a line to be written to b, and returns it.
+func waitForLine(t *testing.T, b *syncBuffer) string {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if s := b.String(); strings.HasSuffix(s, "\n") {
+			return s
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Timed out waiting for access log, got %q", b.String())
+	return ""
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		expect string
+	}{
+		{accessLogCommon, `^127\.0\.0\.1 - alice \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "POST /echo\?x=1 HTTP/1\.1" 200 \d+ \d+ -\n$`},
+		{accessLogCombined, `^127\.0\.0\.1 - alice \[.+\] "POST /echo\?x=1 HTTP/1\.1" 200 \d+ "http://example\.com/" "test-agent" \d+ -\n$`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf syncBuffer
+			server := httptest.NewServer(newAccessLogHandler(newHandler(testConfig(t)), &buf, accessLogSettings{Format: tt.format}))
+			defer server.Close()
+
+			req, _ := http.NewRequest("POST", server.URL+"/echo?x=1", strings.NewReader("hello"))
+			req.SetBasicAuth("alice", "secret")
+			req.Header.Set("Referer", "http://example.com/")
+			req.Header.Set("User-Agent", "test-agent")
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if line := waitForLine(t, &buf); !regexp.MustCompile(tt.expect).MatchString(line) {
+				t.Errorf("Access log %q does not match %s", line, tt.expect)
+			}
+		})
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	var buf syncBuffer
+	server := httptest.NewServer(newAccessLogHandler(newHandler(testConfig(t)), &buf, accessLogSettings{Format: accessLogJSON}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/missing.txt")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	var entry accessLogEntry
+	if err := json.Unmarshal([]byte(waitForLine(t, &buf)), &entry); err != nil {
+		t.Fatalf("Failed to decode access log: %v", err)
+	}
+
+	if entry.Method != "GET" || entry.Path != "/missing.txt" || entry.RemoteAddr != "127.0.0.1" {
+		t.Errorf("Unexpected request fields %+v", entry)
+	}
+	if entry.Status != http.StatusOK || entry.Bytes == 0 {
+		t.Errorf("Unexpected response fields %+v", entry)
+	}
+	if entry.Messages != nil {
+		t.Errorf("Expected no message count for a plain request, got %d", *entry.Messages)
+	}
+}
+
+func TestAccessLogWebSocket(t *testing.T) {
+	var buf syncBuffer
+	server := httptest.NewServer(newAccessLogHandler(newHandler(testConfig(t)), &buf, accessLogSettings{Format: accessLogJSON}))
+	defer server.Close()
+
+	ws, _ := dialWebSocket(t, server, "/")
+	for _, msg := range []string{"one", "two"} {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+		if _, _, err := ws.ReadMessage(); err != nil {
+			t.Fatalf("Failed to read echo: %v", err)
+		}
+	}
+
+	if buf.String() != "" {
+		t.Fatalf("Expected no access log before the connection closes, got %q", buf.String())
+	}
+	ws.Close()
+
+	var entry accessLogEntry
+	if err := json.Unmarshal([]byte(waitForLine(t, &buf)), &entry); err != nil {
+		t.Fatalf("Failed to decode access log: %v", err)
+	}
+
+	if entry.Status != http.StatusSwitchingProtocols {
+		t.Errorf("Expected status 101, got %d", entry.Status)
+	}
+	if entry.Messages == nil || *entry.Messages != 2 {
+		t.Errorf("Expected 2 messages, got %v", entry.Messages)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+
+	expect := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for name, contents := range expect {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if string(data) != contents {
+			t.Errorf("Expected %s to contain %q, got %q", name, contents, data)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups to be kept")
+	}
+}
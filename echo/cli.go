@@ -0,0 +1,165 @@
+package echo
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultHealthcheckTimeout is how long the healthcheck command waits for a
+// response.
+const defaultHealthcheckTimeout = 5 * time.Second
+
+const usage = `Usage: echo-server [command] [flags]
+
+Commands:
+  serve          start the server (the default if no command is given)
+  version        print the version
+  config print   print the effective configuration and exit
+  healthcheck    check that a running server responds, exiting non-zero if not
+  help           show this message
+
+Run "echo-server <command> -h" for the flags accepted by a command.
+`
+
+// Run executes the echo-server command given by args, with the environment
+// given as "KEY=value" strings, and returns the process exit code.
+func Run(args []string, environ []string, stdout, stderr io.Writer) int {
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		cfg, code := loadCommandConfig("serve", args, environ, stderr)
+		if cfg == nil {
+			return code
+		}
+
+		// Interrupts are handled as well as SIGTERM, as Fly stops machines
+		// with SIGINT by default.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if err := serve(ctx, cfg); err != nil {
+			fmt.Fprintf(stderr, "%s\n", err)
+			return 1
+		}
+		return 0
+
+	case "version":
+		if len(args) > 0 {
+			fmt.Fprintf(stderr, "Unexpected argument %q\n", args[0])
+			return 2
+		}
+		fmt.Fprintln(stdout, readVersionInfo())
+		return 0
+
+	case "config":
+		if len(args) == 0 || args[0] != "print" {
+			fmt.Fprintln(stderr, `Usage: echo-server config print [flags]`)
+			return 2
+		}
+
+		cfg, code := loadCommandConfig("config print", args[1:], environ, stderr)
+		if cfg == nil {
+			return code
+		}
+
+		writeConfigSummary(stdout, cfg)
+		return 0
+
+	case "healthcheck":
+		return runHealthcheck(args, environ, stdout, stderr)
+
+	case "help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	}
+
+	fmt.Fprintf(stderr, "Unknown command %q\n\n%s", command, usage)
+	return 2
+}
+
+// loadCommandConfig loads the configuration for a command that accepts the
+// configuration flags. It returns nil and the exit code if the configuration
+// is invalid or help was requested.
+func loadCommandConfig(command string, args []string, environ []string, stderr io.Writer) (*config, int) {
+	cfg, err := loadConfig(args, environ)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(stderr, "Usage: echo-server %s [flags]\n\n", command)
+		writeConfigUsage(stderr)
+		return nil, 0
+	} else if err != nil {
+		fmt.Fprintf(stderr, "Invalid configuration: %s\n", err)
+		return nil, 2
+	}
+
+	return cfg, 0
+}
+
+// runHealthcheck requests a URL on a running server and reports whether it
+// responded with a 2xx status. By default it requests the health endpoint of
+// the server on the configured port.
+func runHealthcheck(args []string, environ []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	target := flags.String("url", "", "URL to request (default http://localhost:<PORT>/.health)")
+	timeout := flags.Duration("timeout", defaultHealthcheckTimeout, "time to wait for a response")
+	insecure := flags.Bool("insecure", false, "skip TLS certificate verification")
+
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+
+	if *target == "" {
+		// The port is read in the same way as by the server, so that the
+		// healthcheck works in the same environment without arguments.
+		cfg, err := loadConfig(nil, environ)
+		if err != nil {
+			fmt.Fprintf(stderr, "Invalid configuration: %s\n", err)
+			return 2
+		}
+		path := cfg.Routes.path("/")
+		if cfg.Routes.Health != "" {
+			path = cfg.Routes.path(cfg.Routes.Health)
+		}
+		*target = fmt.Sprintf("http://localhost:%s%s", cfg.Port, path)
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure}, // nolint:gosec
+		},
+	}
+
+	resp, err := client.Get(*target)
+	if err != nil {
+		fmt.Fprintf(stderr, "Unhealthy: %s\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body) // nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		fmt.Fprintf(stderr, "Unhealthy: %s returned %s\n", *target, resp.Status)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "Healthy: %s returned %s\n", *target, resp.Status)
+	return 0
+}
@@ -0,0 +1,98 @@
+package echo
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRunVersion(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	if code := Run([]string{"version"}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "echo-server dev") {
+		t.Errorf("Unexpected version output %q", stdout.String())
+	}
+}
+
+func TestRunConfigPrint(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := Run([]string{"config", "print", "-port", "9000"}, []string{"LOG_HTTP_BODY=true"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+
+	for _, expect := range []string{"PORT=9000 (flag)", "LOG_HTTP_BODY=true (environment)"} {
+		if !strings.Contains(stdout.String(), expect) {
+			t.Errorf("Output does not contain %q:\n%s", expect, stdout.String())
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		env    []string
+		expect string
+	}{
+		{"UnknownCommand", []string{"start"}, nil, `Unknown command "start"`},
+		{"ConfigWithoutPrint", []string{"config"}, nil, "Usage: echo-server config print"},
+		{"InvalidConfig", []string{"config", "print"}, []string{"PORT=abc"}, `Invalid configuration: invalid PORT "abc"`},
+		{"InvalidFlag", []string{"serve", "-port", "0"}, nil, `Invalid configuration: invalid PORT "0" (from flag)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			if code := Run(tt.args, tt.env, &stdout, &stderr); code != 2 {
+				t.Errorf("Expected exit code 2, got %d", code)
+			}
+			if !strings.Contains(stderr.String(), tt.expect) {
+				t.Errorf("Expected error containing %q, got %q", tt.expect, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunHealthcheck(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, _ *http.Request) {
+		wr.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name   string
+		args   []string
+		env    []string
+		expect int
+	}{
+		{"Healthy", []string{"-url", server.URL}, nil, 0},
+		{"DefaultURL", nil, []string{"PORT=" + server.URL[strings.LastIndex(server.URL, ":")+1:]}, 0},
+		{"ErrorStatus", []string{"-url", failing.URL}, nil, 1},
+		{"Unreachable", []string{"-url", closed.URL, "-timeout", "1s"}, nil, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			code := Run(append([]string{"healthcheck"}, tt.args...), tt.env, &stdout, &stderr)
+			if code != tt.expect {
+				t.Errorf("Expected exit code %d, got %d (%s%s)", tt.expect, code, stdout.String(), stderr.String())
+			}
+		})
+	}
+}
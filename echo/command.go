@@ -0,0 +1,200 @@
+package echo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// commandPrefix marks a text message as an in-band command rather than a
+	// message to be echoed.
+	commandPrefix = "!echo "
+
+	// minPingInterval is the shortest interval at which a client may ask the
+	// server to send pings.
+	minPingInterval = 100 * time.Millisecond
+
+	// controlWriteWait is the time allowed to write a control or raw frame.
+	controlWriteWait = time.Second
+)
+
+// commandHelp describes the in-band commands, and is sent in reply to
+// "!echo help".
+const commandHelp = `Commands:
+!echo ping <interval>             send a ping every interval (e.g. 1s), or "off" to stop
+!echo close <code> [reason]       close the connection with the given code and reason
+!echo fragment <size> <payload>   send payload as a text message in fragments of size bytes
+!echo invalid-utf8                send a text message that is not valid UTF-8
+!echo help                        show this message`
+
+// websocketCommand is an instruction sent by the client to exercise a specific
+// part of the protocol, such as control frames or fragmentation.
+type websocketCommand struct {
+	Name string
+
+	// Interval is the ping interval for the "ping" command. Zero stops pings.
+	Interval time.Duration
+
+	// Code and Reason are the close frame contents for the "close" command.
+	Code   int
+	Reason string
+
+	// FragmentSize and Payload describe the message sent by the "fragment"
+	// command.
+	FragmentSize int
+	Payload      []byte
+}
+
+// parseWebSocketCommand parses a message as a command. It returns false if the
+// message is not a command, in which case it should be echoed as usual.
+func parseWebSocketCommand(messageType int, message []byte) (*websocketCommand, bool, error) {
+	if messageType != websocket.TextMessage {
+		return nil, false, nil
+	}
+
+	text, ok := strings.CutPrefix(string(message), commandPrefix)
+	if !ok {
+		return nil, false, nil
+	}
+
+	name, args, _ := strings.Cut(strings.TrimLeft(text, " "), " ")
+	cmd := &websocketCommand{Name: name}
+
+	switch name {
+	case "ping":
+		interval, err := parsePingInterval(strings.TrimSpace(args))
+		if err != nil {
+			return nil, true, err
+		}
+		cmd.Interval = interval
+
+	case "close":
+		codeArg, reason, _ := strings.Cut(strings.TrimSpace(args), " ")
+		code, err := strconv.Atoi(codeArg)
+		if err != nil || code < 0 || code > 65535 {
+			return nil, true, fmt.Errorf("invalid close code %q", codeArg)
+		}
+		if len(reason) > 123 {
+			return nil, true, fmt.Errorf("close reason must be at most 123 bytes")
+		}
+		cmd.Code = code
+		cmd.Reason = reason
+
+	case "fragment":
+		sizeArg, payload, _ := strings.Cut(strings.TrimLeft(args, " "), " ")
+		size, err := strconv.Atoi(sizeArg)
+		if err != nil || size < 1 {
+			return nil, true, fmt.Errorf("invalid fragment size %q", sizeArg)
+		}
+		cmd.FragmentSize = size
+		cmd.Payload = []byte(payload)
+
+	case "invalid-utf8", "help":
+
+	default:
+		return nil, true, fmt.Errorf("unknown command %q", name)
+	}
+
+	return cmd, true, nil
+}
+
+// parsePingInterval parses the interval for server-initiated pings. An empty
+// value, "0" or "off" disables pings.
+func parsePingInterval(v string) (time.Duration, error) {
+	switch v {
+	case "", "0", "off":
+		return 0, nil
+	}
+
+	interval, err := time.ParseDuration(v)
+	if err != nil || interval < minPingInterval {
+		return 0, fmt.Errorf("invalid ping interval %q: must be a duration of at least %s", v, minPingInterval)
+	}
+
+	return interval, nil
+}
+
+// runWebSocketCommand performs cmd on connection. It returns true if the
+// connection should be closed. The "ping" command is handled by the caller,
+// which owns the ping timer.
+func runWebSocketCommand(connection *websocket.Conn, cmd *websocketCommand) (bool, error) {
+	switch cmd.Name {
+	case "close":
+		err := connection.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(cmd.Code, cmd.Reason),
+			time.Now().Add(controlWriteWait),
+		)
+		return true, err
+
+	case "fragment":
+		return false, writeFragmentedText(connection.UnderlyingConn(), cmd.Payload, cmd.FragmentSize)
+
+	case "invalid-utf8":
+		return false, connection.WriteMessage(websocket.TextMessage, []byte{'b', 'a', 'd', 0xff, 0xfe})
+
+	case "help":
+		return false, connection.WriteMessage(websocket.TextMessage, []byte(commandHelp))
+	}
+
+	return false, nil
+}
+
+// writeFragmentedText writes payload as a text message split into frames of
+// at most size bytes. The frames are written directly to conn because
+// gorilla/websocket does not expose control over fragment boundaries, so the
+// caller must ensure no other writes are in progress.
+func writeFragmentedText(conn net.Conn, payload []byte, size int) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(controlWriteWait)); err != nil {
+		return err
+	}
+	defer conn.SetWriteDeadline(time.Time{}) // nolint:errcheck
+
+	opcode := byte(websocket.TextMessage)
+
+	for {
+		n := min(size, len(payload))
+		final := n == len(payload)
+
+		if err := writeRawFrame(conn, final, opcode, payload[:n]); err != nil {
+			return err
+		}
+
+		if final {
+			return nil
+		}
+
+		payload = payload[n:]
+		opcode = 0 // continuation frame
+	}
+}
+
+// writeRawFrame writes a single unmasked WebSocket frame to conn.
+func writeRawFrame(conn net.Conn, final bool, opcode byte, payload []byte) error {
+	b0 := opcode
+	if final {
+		b0 |= 0x80
+	}
+
+	frame := []byte{b0}
+
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 65535:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	_, err := conn.Write(append(frame, payload...))
+	return err
+}
@@ -0,0 +1,289 @@
+package echo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseWebSocketCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		messageType int
+		message     string
+		isCommand   bool
+		expectErr   bool
+		check       func(t *testing.T, cmd *websocketCommand)
+	}{
+		{name: "PlainText", messageType: websocket.TextMessage, message: "hello"},
+		{name: "Binary", messageType: websocket.BinaryMessage, message: "!echo help"},
+		{
+			name: "Ping", messageType: websocket.TextMessage, message: "!echo ping 250ms", isCommand: true,
+			check: func(t *testing.T, cmd *websocketCommand) {
+				if cmd.Name != "ping" || cmd.Interval != 250*time.Millisecond {
+					t.Errorf("Unexpected command %+v", cmd)
+				}
+			},
+		},
+		{
+			name: "PingOff", messageType: websocket.TextMessage, message: "!echo ping off", isCommand: true,
+			check: func(t *testing.T, cmd *websocketCommand) {
+				if cmd.Interval != 0 {
+					t.Errorf("Expected pings to be disabled, got %s", cmd.Interval)
+				}
+			},
+		},
+		{
+			name: "Close", messageType: websocket.TextMessage, message: "!echo close 4000 going away now", isCommand: true,
+			check: func(t *testing.T, cmd *websocketCommand) {
+				if cmd.Code != 4000 || cmd.Reason != "going away now" {
+					t.Errorf("Unexpected command %+v", cmd)
+				}
+			},
+		},
+		{
+			name: "Fragment", messageType: websocket.TextMessage, message: "!echo fragment 3 hello world", isCommand: true,
+			check: func(t *testing.T, cmd *websocketCommand) {
+				if cmd.FragmentSize != 3 || string(cmd.Payload) != "hello world" {
+					t.Errorf("Unexpected command %+v", cmd)
+				}
+			},
+		},
+		{name: "PingTooFast", messageType: websocket.TextMessage, message: "!echo ping 1ms", isCommand: true, expectErr: true},
+		{name: "CloseInvalidCode", messageType: websocket.TextMessage, message: "!echo close abc", isCommand: true, expectErr: true},
+		{name: "FragmentInvalidSize", messageType: websocket.TextMessage, message: "!echo fragment 0 x", isCommand: true, expectErr: true},
+		{name: "Unknown", messageType: websocket.TextMessage, message: "!echo dance", isCommand: true, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, ok, err := parseWebSocketCommand(tt.messageType, []byte(tt.message))
+
+			if ok != tt.isCommand {
+				t.Fatalf("Expected isCommand=%v, got %v", tt.isCommand, ok)
+			}
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("Expected error=%v, got %v", tt.expectErr, err)
+			}
+			if tt.check != nil {
+				tt.check(t, cmd)
+			}
+		})
+	}
+}
+
+func TestWriteFragmentedText(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+		_ = writeFragmentedText(server, []byte("hello"), 2)
+	}()
+
+	frames, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("Failed to read frames: %v", err)
+	}
+
+	expect := []byte{
+		0x01, 2, 'h', 'e', // text, not final
+		0x00, 2, 'l', 'l', // continuation, not final
+		0x80, 1, 'o', // continuation, final
+	}
+	if !bytes.Equal(frames, expect) {
+		t.Errorf("Unexpected frames %v, expected %v", frames, expect)
+	}
+}
+
+func TestWebSocketPingCommand(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	ws, _ := dialWebSocket(t, server, "/")
+
+	pinged := make(chan string, 1)
+	ws.SetPingHandler(func(appData string) error {
+		select {
+		case pinged <- appData:
+		default:
+		}
+		return nil
+	})
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("!echo ping 100ms")); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+
+	// Control frames are only processed while reading.
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case appData := <-pinged:
+		if _, err := time.Parse(time.RFC3339Nano, appData); err != nil {
+			t.Errorf("Expected ping payload to be a timestamp, got %q", appData)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Did not receive a ping from the server")
+	}
+}
+
+func TestWebSocketPingIntervalQuery(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	ws, _ := dialWebSocket(t, server, "/?ping_interval=100ms")
+
+	pinged := make(chan struct{}, 1)
+	ws.SetPingHandler(func(string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-pinged:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Did not receive a ping from the server")
+	}
+}
+
+func TestWebSocketClientPing(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	ws, _ := dialWebSocket(t, server, "/")
+
+	ponged := make(chan string, 1)
+	ws.SetPongHandler(func(appData string) error {
+		ponged <- appData
+		return nil
+	})
+
+	if err := ws.WriteControl(websocket.PingMessage, []byte("are you there"), time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("Failed to send ping: %v", err)
+	}
+
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case appData := <-ponged:
+		if appData != "are you there" {
+			t.Errorf("Expected pong to echo the ping payload, got %q", appData)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Did not receive a pong from the server")
+	}
+}
+
+func TestWebSocketCloseCommand(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	ws, _ := dialWebSocket(t, server, "/")
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("!echo close 4001 custom reason")); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := ws.ReadMessage()
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("Expected a close error, got %v", err)
+	}
+	if closeErr.Code != 4001 || closeErr.Text != "custom reason" {
+		t.Errorf("Expected close 4001 'custom reason', got %d %q", closeErr.Code, closeErr.Text)
+	}
+}
+
+func TestWebSocketFragmentAndInvalidUTF8Commands(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	ws, _ := dialWebSocket(t, server, "/")
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("!echo fragment 4 fragmented message")); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+
+	messageType, msg, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read fragmented message: %v", err)
+	}
+	if messageType != websocket.TextMessage || string(msg) != "fragmented message" {
+		t.Errorf("Expected reassembled text 'fragmented message', got %q", msg)
+	}
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("!echo invalid-utf8")); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+
+	messageType, msg, err = ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read invalid message: %v", err)
+	}
+	if messageType != websocket.TextMessage || utf8.Valid(msg) {
+		t.Errorf("Expected a text message with invalid UTF-8, got %q", msg)
+	}
+
+	// Echo continues to work after the commands.
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("still here")); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if _, msg, err := ws.ReadMessage(); err != nil || string(msg) != "still here" {
+		t.Errorf("Expected echo 'still here', got %q (%v)", msg, err)
+	}
+}
+
+func TestWebSocketCommandError(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	ws, _ := dialWebSocket(t, server, "/")
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("!echo dance")); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+
+	_, msg, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	if !strings.Contains(string(msg), `unknown command "dance"`) {
+		t.Errorf("Expected an unknown command error, got %q", msg)
+	}
+}
@@ -0,0 +1,853 @@
+package echo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultPort is the port used for the HTTP listener when PORT is not set.
+	defaultPort = "8080"
+
+	// sendHeaderPrefix prefixes the options that add a header to every
+	// response. The rest of the name is the header name, with underscores
+	// converted to hyphens.
+	sendHeaderPrefix = "SEND_HEADER_"
+)
+
+// config is the server configuration. It is loaded once at startup from, in
+// increasing order of precedence, the defaults, an optional config file,
+// environment variables and command-line flags.
+type config struct {
+	Port    string
+	TLSPort string
+
+	TLSCertFile        string
+	TLSKeyFile         string
+	TLSSelfSigned      bool
+	TLSSelfSignedHosts []string
+	TLSClientAuth      string
+	TLSClientCAFile    string
+
+	LogLevel           slog.Level
+	LogFormat          string
+	LogHTTPHeaders     bool
+	LogHTTPBody        bool
+	AccessLog          accessLogSettings
+	SendServerHostname bool
+	SendHeaders        http.Header
+	InstanceID         string
+	InstanceMetadata   []string
+
+	// Instance is resolved from InstanceID and InstanceMetadata when the
+	// configuration is loaded.
+	Instance instanceIdentity
+
+	ConnectionTimeout time.Duration
+	ShutdownTimeout   time.Duration
+	SSERetry          time.Duration
+
+	WebSocketSubprotocols []string
+	WebSocketCompression  compressionSettings
+	WebSocketLimits       websocketLimits
+	AllowedOrigins        originPolicy
+	Limits                limitSettings
+	TrustedProxies        trustedProxies
+	ProxyProtocol         bool
+	Routes                routeSettings
+
+	// File is the config file that was loaded, if any.
+	File string
+
+	// sources records where each option that is not a default was set.
+	sources map[string]string
+}
+
+// defaultConfig returns the configuration used when no options are set.
+func defaultConfig() *config {
+	return &config{
+		Port:                  defaultPort,
+		TLSPort:               defaultTLSPort,
+		TLSClientAuth:         "none",
+		LogLevel:              slog.LevelInfo,
+		LogFormat:             logFormatText,
+		SendServerHostname:    true,
+		SendHeaders:           http.Header{},
+		InstanceMetadata:      defaultInstanceMetadata,
+		ConnectionTimeout:     defaultConnectionTimeoutMinutes * time.Minute,
+		ShutdownTimeout:       defaultShutdownTimeout,
+		WebSocketSubprotocols: []string{jsonSubprotocol},
+		WebSocketCompression:  compressionSettings{Level: defaultCompressionLevel},
+		AllowedOrigins:        originPolicy{AllowAll: true},
+		Routes:                defaultRouteSettings(),
+		sources:               map[string]string{},
+		AccessLog: accessLogSettings{
+			Format:     accessLogOff,
+			MaxSize:    defaultAccessLogMaxSize,
+			MaxBackups: defaultAccessLogMaxBackups,
+		},
+	}
+}
+
+// configOption is a single setting. It is read from the environment variable
+// name, the config file key and flag derived from it, e.g. "tls_port" and
+// -tls-port for TLS_PORT.
+type configOption struct {
+	name  string
+	usage string
+	set   func(c *config, v string) error
+
+	// get formats the current value for the configuration summary. It is nil
+	// for deprecated aliases, which are not shown.
+	get func(c *config) string
+}
+
+func (o configOption) key() string {
+	return strings.ToLower(o.name)
+}
+
+func (o configOption) flagName() string {
+	return strings.ReplaceAll(o.key(), "_", "-")
+}
+
+// configOptions lists every option in the order they are applied and shown.
+var configOptions = []configOption{
+	{
+		name:  "PORT",
+		usage: "port for the HTTP listener",
+		set:   func(c *config, v string) error { return setPort(&c.Port, v) },
+		get:   func(c *config) string { return c.Port },
+	},
+	{
+		name:  "TLS_PORT",
+		usage: "port for the TLS listener",
+		set:   func(c *config, v string) error { return setPort(&c.TLSPort, v) },
+		get:   func(c *config) string { return c.TLSPort },
+	},
+	stringOption("TLS_CERT_FILE", "PEM certificate file for the TLS listener", func(c *config) *string { return &c.TLSCertFile }),
+	stringOption("TLS_KEY_FILE", "PEM private key file for the TLS listener", func(c *config) *string { return &c.TLSKeyFile }),
+	boolOption("TLS_SELF_SIGNED", "serve TLS with a generated self-signed certificate", func(c *config) *bool { return &c.TLSSelfSigned }),
+	listOption("TLS_SELF_SIGNED_HOSTS", "additional hosts for the self-signed certificate", func(c *config) *[]string { return &c.TLSSelfSignedHosts }),
+	{
+		name:  "TLS_CLIENT_AUTH",
+		usage: "client certificate mode: none, request, require, verify or require-and-verify",
+		set: func(c *config, v string) error {
+			mode := strings.ToLower(v)
+			switch mode {
+			case "none", "request", "require", "verify", "require-and-verify":
+				c.TLSClientAuth = mode
+				return nil
+			}
+			return errors.New("must be none, request, require, verify or require-and-verify")
+		},
+		get: func(c *config) string { return c.TLSClientAuth },
+	},
+	stringOption("TLS_CLIENT_CA_FILE", "PEM CA bundle used to verify client certificates", func(c *config) *string { return &c.TLSClientCAFile }),
+	{
+		name:  "LOG_LEVEL",
+		usage: "minimum level of log records: debug, info, warn or error",
+		set: func(c *config, v string) error {
+			if err := c.LogLevel.UnmarshalText([]byte(v)); err != nil {
+				return errors.New("must be debug, info, warn or error")
+			}
+			return nil
+		},
+		get: func(c *config) string { return strings.ToLower(c.LogLevel.String()) },
+	},
+	{
+		name:  "LOG_FORMAT",
+		usage: "log output format: text or json",
+		set: func(c *config, v string) error {
+			switch format := strings.ToLower(v); format {
+			case logFormatText, logFormatJSON:
+				c.LogFormat = format
+				return nil
+			}
+			return errors.New("must be text or json")
+		},
+		get: func(c *config) string { return c.LogFormat },
+	},
+	switchOption("LOG_HTTP_HEADERS", "log request headers at debug level", func(c *config) *bool { return &c.LogHTTPHeaders }),
+	switchOption("LOG_HTTP_BODY", "log request bodies at debug level", func(c *config) *bool { return &c.LogHTTPBody }),
+	{
+		name:  "ACCESS_LOG",
+		usage: "access log format: off, common, combined or json",
+		set: func(c *config, v string) error {
+			switch format := strings.ToLower(v); format {
+			case accessLogOff, accessLogCommon, accessLogCombined, accessLogJSON:
+				c.AccessLog.Format = format
+				return nil
+			}
+			return errors.New("must be off, common, combined or json")
+		},
+		get: func(c *config) string { return c.AccessLog.Format },
+	},
+	stringOption("ACCESS_LOG_FILE", "file to write the access log to instead of STDOUT", func(c *config) *string { return &c.AccessLog.File }),
+	sizeOption("ACCESS_LOG_MAX_SIZE", "size in bytes at which the access log file is rotated (0 to never rotate)", func(c *config) *int64 { return &c.AccessLog.MaxSize }),
+	sizeOption("ACCESS_LOG_MAX_BACKUPS", "number of rotated access log files to keep", func(c *config) *int { return &c.AccessLog.MaxBackups }),
+	boolOption("ACCESS_LOG_PROBES", "include health, readiness and version requests in the access log", func(c *config) *bool { return &c.AccessLog.Probes }),
+	boolOption("SEND_SERVER_HOSTNAME", "include the server hostname in responses", func(c *config) *bool { return &c.SendServerHostname }),
+	stringOption("INSTANCE_ID", "identity reported in responses instead of the hostname", func(c *config) *string { return &c.InstanceID }),
+	listOption("INSTANCE_METADATA", "environment variables reported with the instance identity", func(c *config) *[]string { return &c.InstanceMetadata }),
+	{
+		// Deprecated alias for CONNECTION_TIMEOUT_MINUTES, which is applied
+		// after it and so takes precedence.
+		name:  "WEBSOCKET_TIMEOUT_MINUTES",
+		usage: "deprecated: use connection-timeout-minutes",
+		set:   func(c *config, v string) error { return setMinutes(&c.ConnectionTimeout, v) },
+	},
+	{
+		name:  "CONNECTION_TIMEOUT_MINUTES",
+		usage: "maximum duration of WebSocket and SSE connections, in minutes",
+		set:   func(c *config, v string) error { return setMinutes(&c.ConnectionTimeout, v) },
+		get: func(c *config) string {
+			return strconv.FormatFloat(c.ConnectionTimeout.Minutes(), 'f', -1, 64)
+		},
+	},
+	{
+		name:  "SHUTDOWN_TIMEOUT_SECONDS",
+		usage: "time allowed for connections to close on shutdown, in seconds",
+		set:   func(c *config, v string) error { return setSeconds(&c.ShutdownTimeout, v) },
+		get: func(c *config) string {
+			return strconv.FormatFloat(c.ShutdownTimeout.Seconds(), 'f', -1, 64)
+		},
+	},
+	{
+		name:  "SSE_RETRY_SECONDS",
+		usage: "reconnection delay sent to SSE clients in the retry field, in seconds",
+		set:   func(c *config, v string) error { return setSeconds(&c.SSERetry, v) },
+		get: func(c *config) string {
+			return strconv.FormatFloat(c.SSERetry.Seconds(), 'f', -1, 64)
+		},
+	},
+	{
+		name:  "WEBSOCKET_SUBPROTOCOLS",
+		usage: `WebSocket subprotocols to accept, or "*" to accept the first one offered`,
+		set: func(c *config, v string) error {
+			c.WebSocketSubprotocols = splitList(v)
+			return nil
+		},
+		get: func(c *config) string { return strings.Join(c.WebSocketSubprotocols, ",") },
+	},
+	boolOption("WEBSOCKET_COMPRESSION", "negotiate permessage-deflate", func(c *config) *bool { return &c.WebSocketCompression.Enabled }),
+	{
+		name:  "WEBSOCKET_COMPRESSION_LEVEL",
+		usage: "flate compression level, from -2 to 9",
+		set: func(c *config, v string) error {
+			level, err := strconv.Atoi(v)
+			if err != nil || !isValidCompressionLevel(level) {
+				return errors.New("must be an integer between -2 and 9")
+			}
+			c.WebSocketCompression.Level = level
+			return nil
+		},
+		get: func(c *config) string { return strconv.Itoa(c.WebSocketCompression.Level) },
+	},
+	sizeOption("WEBSOCKET_READ_BUFFER_SIZE", "WebSocket read buffer size in bytes (0 for the default)", func(c *config) *int { return &c.WebSocketLimits.ReadBufferSize }),
+	sizeOption("WEBSOCKET_WRITE_BUFFER_SIZE", "WebSocket write buffer size in bytes (0 for the default)", func(c *config) *int { return &c.WebSocketLimits.WriteBufferSize }),
+	sizeOption("WEBSOCKET_MAX_MESSAGE_SIZE", "largest WebSocket message accepted in bytes (0 for unlimited)", func(c *config) *int64 { return &c.WebSocketLimits.MaxMessageSize }),
+	{
+		name:  "ALLOWED_ORIGINS",
+		usage: `origins allowed to open WebSockets, "same-origin" or "*"`,
+		set: func(c *config, v string) error {
+			c.AllowedOrigins = parseOriginPolicy(v)
+			return nil
+		},
+		get: func(c *config) string { return c.AllowedOrigins.String() },
+	},
+	sizeOption("MAX_CONNECTIONS", "maximum open WebSocket and SSE connections (0 for unlimited)", func(c *config) *int { return &c.Limits.MaxConnections }),
+	sizeOption("MAX_CONNECTIONS_PER_IP", "maximum open WebSocket and SSE connections per client IP (0 for unlimited)", func(c *config) *int { return &c.Limits.MaxConnectionsPerIP }),
+	rateOption("RATE_LIMIT", "maximum requests per second (0 for unlimited)", func(c *config) *float64 { return &c.Limits.RateLimit }),
+	rateOption("RATE_LIMIT_PER_IP", "maximum requests per second per client IP (0 for unlimited)", func(c *config) *float64 { return &c.Limits.RateLimitPerIP }),
+	{
+		name:  "TRUSTED_PROXIES",
+		usage: `proxies whose forwarding headers are trusted, as CIDR ranges, IPs, "loopback" or "private" (comma-separated)`,
+		set: func(c *config, v string) (err error) {
+			c.TrustedProxies, err = parseTrustedProxies(v)
+			return err
+		},
+		get: func(c *config) string { return c.TrustedProxies.String() },
+	},
+	boolOption("PROXY_PROTOCOL", "accept PROXY protocol v1 and v2 headers from trusted proxies", func(c *config) *bool { return &c.ProxyProtocol }),
+	{
+		name:  "PATH_PREFIX",
+		usage: "path the server is mounted at, which every route is relative to",
+		set:   func(c *config, v string) error { return setPathPrefix(&c.Routes.Prefix, v) },
+		get:   func(c *config) string { return c.Routes.Prefix },
+	},
+	listOption("WEBSOCKET_PATHS", "the only paths that accept, and require, WebSocket upgrades", func(c *config) *[]string { return &c.Routes.WebSocketPaths }),
+	routeOption("WEBSOCKET_UI_PATH", "path of the WebSocket test page", func(c *config) *string { return &c.Routes.WebSocketUI }),
+	routeOption("SSE_PATH", "path of the Server-Sent Events endpoint", func(c *config) *string { return &c.Routes.SSE }),
+	routeOption("SSE_PUBLISH_PATH", "path to which messages are POSTed to be sent on SSE streams", func(c *config) *string { return &c.Routes.SSEPublish }),
+	routeOption("METRICS_PATH", "path of the metrics endpoint", func(c *config) *string { return &c.Routes.Metrics }),
+	routeOption("HEALTH_PATH", "path of the health endpoint", func(c *config) *string { return &c.Routes.Health }),
+	routeOption("READY_PATH", "path of the readiness endpoint", func(c *config) *string { return &c.Routes.Ready }),
+	routeOption("VERSION_PATH", "path of the version endpoint", func(c *config) *string { return &c.Routes.Version }),
+	routeOption("ROUTES_PATH", "path of the endpoint listing the routes", func(c *config) *string { return &c.Routes.Routes }),
+}
+
+func stringOption(name, usage string, field func(*config) *string) configOption {
+	return configOption{
+		name:  name,
+		usage: usage,
+		set: func(c *config, v string) error {
+			*field(c) = v
+			return nil
+		},
+		get: func(c *config) string { return *field(c) },
+	}
+}
+
+func boolOption(name, usage string, field func(*config) *bool) configOption {
+	return configOption{
+		name:  name,
+		usage: usage,
+		set: func(c *config, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return errors.New("must be true or false")
+			}
+			*field(c) = b
+			return nil
+		},
+		get: func(c *config) string { return strconv.FormatBool(*field(c)) },
+	}
+}
+
+// switchOption is a boolean option that was previously turned on by any
+// non-empty value. Values that are not true or false still turn it on, so
+// that existing deployments keep working.
+func switchOption(name, usage string, field func(*config) *bool) configOption {
+	return configOption{
+		name:  name,
+		usage: usage,
+		set: func(c *config, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				b = v != ""
+			}
+			*field(c) = b
+			return nil
+		},
+		get: func(c *config) string { return strconv.FormatBool(*field(c)) },
+	}
+}
+
+func listOption(name, usage string, field func(*config) *[]string) configOption {
+	return configOption{
+		name:  name,
+		usage: usage + " (comma-separated)",
+		set: func(c *config, v string) error {
+			*field(c) = splitList(v)
+			return nil
+		},
+		get: func(c *config) string { return strings.Join(*field(c), ",") },
+	}
+}
+
+func routeOption(name, usage string, field func(*config) *string) configOption {
+	return configOption{
+		name:  name,
+		usage: usage + ` ("off" to disable)`,
+		set:   func(c *config, v string) error { return setRoutePath(field(c), v) },
+		get: func(c *config) string {
+			if *field(c) == "" {
+				return routeOff
+			}
+			return *field(c)
+		},
+	}
+}
+
+func rateOption(name, usage string, field func(*config) *float64) configOption {
+	return configOption{
+		name:  name,
+		usage: usage,
+		set: func(c *config, v string) error {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+				return errors.New("must be a non-negative number")
+			}
+			*field(c) = n
+			return nil
+		},
+		get: func(c *config) string { return strconv.FormatFloat(*field(c), 'f', -1, 64) },
+	}
+}
+
+func sizeOption[T int | int64](name, usage string, field func(*config) *T) configOption {
+	return configOption{
+		name:  name,
+		usage: usage,
+		set: func(c *config, v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return errors.New("must be a non-negative integer")
+			}
+			*field(c) = T(n)
+			return nil
+		},
+		get: func(c *config) string { return strconv.FormatInt(int64(*field(c)), 10) },
+	}
+}
+
+func setPort(port *string, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > 65535 {
+		return errors.New("must be a port number between 1 and 65535")
+	}
+	*port = v
+	return nil
+}
+
+func setMinutes(d *time.Duration, v string) error {
+	return setDuration(d, v, time.Minute, "minutes")
+}
+
+func setSeconds(d *time.Duration, v string) error {
+	return setDuration(d, v, time.Second, "seconds")
+}
+
+// setDuration sets d to v, a positive number of units.
+func setDuration(d *time.Duration, v string, unit time.Duration, unitName string) error {
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("must be a positive number of %s", unitName)
+	}
+	*d = time.Duration(n * float64(unit))
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadConfig builds the configuration from the command-line arguments and the
+// environment, given as "KEY=value" strings. The config file is named by the
+// -config flag or the CONFIG_FILE environment variable.
+func loadConfig(args []string, environ []string) (*config, error) {
+	c := defaultConfig()
+
+	// Flags are recorded and applied last, after the config file and the
+	// environment.
+	flagValues := map[string]string{}
+	flags, configFile := newConfigFlagSet(flagValues)
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	envValues := environMap(environ)
+
+	if *configFile == "" {
+		*configFile = envValues["CONFIG_FILE"]
+	}
+
+	if *configFile != "" {
+		fileValues, err := readConfigFile(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read config file %s: %w", *configFile, err)
+		}
+		if err := c.apply(fileValues, "file"); err != nil {
+			return nil, err
+		}
+		c.File = *configFile
+	}
+
+	if err := c.apply(envValues, "environment"); err != nil {
+		return nil, err
+	}
+
+	if err := c.apply(flagValues, "flag"); err != nil {
+		return nil, err
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	c.Instance = resolveInstance(c.InstanceID, c.InstanceMetadata, envValues)
+
+	// The request dumps are logged at debug level, so enabling them lowers
+	// the level unless it was set explicitly.
+	if (c.LogHTTPHeaders || c.LogHTTPBody) && c.source("LOG_LEVEL") == "default" {
+		c.LogLevel = slog.LevelDebug
+	}
+
+	return c, nil
+}
+
+// environMap converts an environment given as "KEY=value" strings to a map.
+// Empty variables are treated as unset.
+func environMap(environ []string) map[string]string {
+	values := map[string]string{}
+	for _, line := range environ {
+		key, value, _ := strings.Cut(line, "=")
+		if value != "" {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// newConfigFlagSet returns a flag set with a flag for every option, which
+// records the values that are set in values, keyed by option name.
+func newConfigFlagSet(values map[string]string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet("echo-server", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	configFile := flags.String("config", "", "path to a JSON, YAML or TOML config file")
+
+	for _, opt := range configOptions {
+		name := opt.name
+		flags.Func(opt.flagName(), opt.usage, func(v string) error {
+			values[name] = v
+			return nil
+		})
+	}
+	flags.Func("send-header", `add a response header, as "Name: Value" (repeatable)`, func(v string) error {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return errors.New(`must be "Name: Value"`)
+		}
+		values[sendHeaderPrefix+strings.TrimSpace(name)] = strings.TrimSpace(value)
+		return nil
+	})
+
+	return flags, configFile
+}
+
+// writeConfigUsage writes the description of every configuration flag to w.
+func writeConfigUsage(w io.Writer) {
+	flags, _ := newConfigFlagSet(map[string]string{})
+	flags.SetOutput(w)
+	flags.PrintDefaults()
+}
+
+// apply sets the options in values, which are keyed by option name, and
+// records source as where they came from. Options are applied in the order
+// of configOptions, followed by the response headers.
+func (c *config) apply(values map[string]string, source string) error {
+	for _, opt := range configOptions {
+		v, ok := values[opt.name]
+		if !ok {
+			continue
+		}
+
+		if err := opt.set(c, v); err != nil {
+			return fmt.Errorf("invalid %s %q (from %s): %w", opt.name, v, source, err)
+		}
+		c.sources[opt.name] = source
+	}
+
+	var headers []string
+	for name := range values {
+		if strings.HasPrefix(name, sendHeaderPrefix) {
+			headers = append(headers, name)
+		}
+	}
+	sort.Strings(headers)
+
+	for _, name := range headers {
+		header := strings.ReplaceAll(strings.TrimPrefix(name, sendHeaderPrefix), "_", "-")
+		c.SendHeaders.Set(header, values[name])
+		c.sources[sendHeaderPrefix+http.CanonicalHeaderKey(header)] = source
+	}
+
+	return nil
+}
+
+// validate checks the options that depend on each other.
+func (c *config) validate() error {
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	switch c.TLSClientAuth {
+	case "verify", "require-and-verify":
+		if c.TLSClientCAFile == "" {
+			return fmt.Errorf("TLS_CLIENT_CA_FILE must be set when TLS_CLIENT_AUTH is %q", c.TLSClientAuth)
+		}
+	}
+
+	if c.AccessLog.File != "" && c.AccessLog.Format == accessLogOff {
+		return errors.New("ACCESS_LOG must be set when ACCESS_LOG_FILE is set")
+	}
+
+	if c.ProxyProtocol && len(c.TrustedProxies.prefixes) == 0 {
+		return errors.New("TRUSTED_PROXIES must be set when PROXY_PROTOCOL is enabled")
+	}
+
+	if c.TLSEnabled() && c.TLSPort == c.Port {
+		return fmt.Errorf("PORT and TLS_PORT must be different, both are %s", c.Port)
+	}
+
+	return c.Routes.validate()
+}
+
+// TLSEnabled returns true if the TLS listener should be started.
+func (c *config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || c.TLSSelfSigned
+}
+
+// writeConfigSummary writes the effective value of every option to w, along
+// with where it was set.
+func writeConfigSummary(w io.Writer, c *config) {
+	fmt.Fprintln(w, "Effective configuration:")
+	if c.File != "" {
+		fmt.Fprintf(w, "  (config file %s)\n", c.File)
+	}
+
+	for _, opt := range configOptions {
+		if opt.get == nil {
+			continue
+		}
+		fmt.Fprintf(w, "  %s=%s (%s)\n", opt.name, opt.get(c), c.source(opt.name))
+	}
+
+	var headers []string
+	for header := range c.SendHeaders {
+		headers = append(headers, header)
+	}
+	sort.Strings(headers)
+
+	for _, header := range headers {
+		name := sendHeaderPrefix + header
+		fmt.Fprintf(w, "  %s=%s (%s)\n", name, c.SendHeaders.Get(header), c.source(name))
+	}
+}
+
+func (c *config) source(name string) string {
+	if source, ok := c.sources[name]; ok {
+		return source
+	}
+	return "default"
+}
+
+// readConfigFile reads the options in a config file, keyed by option name. The
+// format is chosen by the file extension: .json, .yaml, .yml or .toml. Only
+// flat files of scalar values and lists are supported.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]string
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		values, err = parseJSONConfig(data)
+	case ".yaml", ".yml":
+		values, err = parseFlatConfig(data, ':')
+	case ".toml":
+		values, err = parseFlatConfig(data, '=')
+	default:
+		return nil, fmt.Errorf("unsupported config file extension %q: must be .json, .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys are the lowercase option names, e.g. "tls_port" for TLS_PORT.
+	options := map[string]string{}
+	for key, value := range values {
+		name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		if !isConfigOption(name) {
+			return nil, fmt.Errorf("unknown option %q", key)
+		}
+		options[name] = value
+	}
+
+	return options, nil
+}
+
+func isConfigOption(name string) bool {
+	if strings.HasPrefix(name, sendHeaderPrefix) && len(name) > len(sendHeaderPrefix) {
+		return true
+	}
+	for _, opt := range configOptions {
+		if opt.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseJSONConfig parses a JSON object of scalar values and lists. Lists are
+// joined with commas.
+func parseJSONConfig(data []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for key, value := range raw {
+		switch v := value.(type) {
+		case nil:
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case map[string]interface{}:
+			return nil, fmt.Errorf("option %q must be a scalar or a list", key)
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+
+	return values, nil
+}
+
+// parseFlatConfig parses the flat subset of YAML (with sep ':') or TOML (with
+// sep '=') used for config files: one "key: value" or "key = value" per line,
+// with quoted or bare values, inline [a, b] lists, and # comments. In YAML, a
+// key with no value may be followed by "- item" lines.
+func parseFlatConfig(data []byte, sep byte) (map[string]string, error) {
+	values := map[string]string{}
+
+	var listKey string
+	for i, line := range strings.Split(string(data), "\n") {
+		lineNo := i + 1
+		line = strings.TrimSpace(stripComment(line))
+
+		switch {
+		case line == "", line == "---":
+			continue
+
+		case strings.HasPrefix(line, "- ") && sep == ':' && listKey != "":
+			item, err := unquoteConfigValue(strings.TrimSpace(line[2:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			if values[listKey] != "" {
+				item = "," + item
+			}
+			values[listKey] += item
+			continue
+
+		case strings.HasPrefix(line, "["):
+			return nil, fmt.Errorf("line %d: tables are not supported", lineNo)
+		}
+
+		key, value, ok := strings.Cut(line, string(sep))
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key%cvalue", lineNo, sep)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		if unquoted, err := strconv.Unquote(key); err == nil {
+			key = unquoted
+		}
+
+		listKey = ""
+		if value == "" && sep == ':' {
+			listKey = key
+			values[key] = ""
+			continue
+		}
+
+		parsed, err := parseConfigValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		values[key] = parsed
+	}
+
+	return values, nil
+}
+
+// parseConfigValue parses a scalar value or an inline list, which is returned
+// joined with commas.
+func parseConfigValue(value string) (string, error) {
+	inner, ok := strings.CutPrefix(value, "[")
+	if !ok {
+		return unquoteConfigValue(value)
+	}
+
+	inner, ok = strings.CutSuffix(inner, "]")
+	if !ok {
+		return "", fmt.Errorf("unterminated list %s", value)
+	}
+
+	var items []string
+	for _, item := range strings.Split(inner, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		unquoted, err := unquoteConfigValue(item)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, unquoted)
+	}
+
+	return strings.Join(items, ","), nil
+}
+
+// unquoteConfigValue removes double or single quotes from a value.
+func unquoteConfigValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %s", value)
+		}
+		return unquoted, nil
+
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("invalid quoted string %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	}
+
+	return value, nil
+}
+
+// stripComment removes a # comment from line, ignoring # inside quotes.
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
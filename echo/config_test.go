@@ -0,0 +1,261 @@
+package echo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig returns the configuration loaded from env, given as "KEY=value"
+// strings, without reading the process environment.
+func testConfig(t *testing.T, env ...string) *config {
+	t.Helper()
+
+	cfg, err := loadConfig(nil, env)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	return cfg
+}
+
+// writeConfigFile writes a config file with the given name and contents to a
+// temporary directory and returns its path.
+func writeConfigFile(t *testing.T, name, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg := testConfig(t)
+
+	if cfg.Port != "8080" || cfg.TLSPort != "8443" {
+		t.Errorf("Unexpected default ports %s and %s", cfg.Port, cfg.TLSPort)
+	}
+	if cfg.ConnectionTimeout != 10*time.Minute {
+		t.Errorf("Expected default timeout of 10 minutes, got %s", cfg.ConnectionTimeout)
+	}
+	if !cfg.SendServerHostname || !cfg.AllowedOrigins.AllowAll || cfg.TLSEnabled() {
+		t.Errorf("Unexpected defaults %+v", cfg)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "echo.json", `{
+		"port": 9000,
+		"tls_port": "9443",
+		"log_http_headers": true,
+		"websocket_subprotocols": ["chat", "mqtt"]
+	}`)
+
+	cfg, err := loadConfig(
+		[]string{"-config", path, "-port", "9002", "-send-header", "X-Flag: yes"},
+		[]string{"PORT=9001", "TLS_PORT=9444", "SEND_HEADER_X_ENV=env"},
+	)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Port != "9002" {
+		t.Errorf("Expected flag to override environment, got port %s", cfg.Port)
+	}
+	if cfg.TLSPort != "9444" {
+		t.Errorf("Expected environment to override file, got TLS port %s", cfg.TLSPort)
+	}
+	if !cfg.LogHTTPHeaders {
+		t.Errorf("Expected LOG_HTTP_HEADERS from file")
+	}
+	if strings.Join(cfg.WebSocketSubprotocols, ",") != "chat,mqtt" {
+		t.Errorf("Unexpected subprotocols %v", cfg.WebSocketSubprotocols)
+	}
+	if cfg.SendHeaders.Get("X-Env") != "env" || cfg.SendHeaders.Get("X-Flag") != "yes" {
+		t.Errorf("Unexpected headers %v", cfg.SendHeaders)
+	}
+
+	var summary strings.Builder
+	writeConfigSummary(&summary, cfg)
+
+	for _, expect := range []string{
+		"PORT=9002 (flag)\n",
+		"TLS_PORT=9444 (environment)\n",
+		"LOG_HTTP_HEADERS=true (file)\n",
+		"LOG_HTTP_BODY=false (default)\n",
+		"SEND_HEADER_X-Env=env (environment)\n",
+	} {
+		if !strings.Contains(summary.String(), expect) {
+			t.Errorf("Summary does not contain %q:\n%s", expect, summary.String())
+		}
+	}
+}
+
+func TestLoadConfigTimeoutAlias(t *testing.T) {
+	cfg := testConfig(t, "WEBSOCKET_TIMEOUT_MINUTES=2")
+	if cfg.ConnectionTimeout != 2*time.Minute {
+		t.Errorf("Expected WEBSOCKET_TIMEOUT_MINUTES to set the timeout, got %s", cfg.ConnectionTimeout)
+	}
+
+	cfg = testConfig(t, "WEBSOCKET_TIMEOUT_MINUTES=2", "CONNECTION_TIMEOUT_MINUTES=0.5")
+	if cfg.ConnectionTimeout != 30*time.Second {
+		t.Errorf("Expected CONNECTION_TIMEOUT_MINUTES to take precedence, got %s", cfg.ConnectionTimeout)
+	}
+}
+
+func TestLoadConfigHTTPLogging(t *testing.T) {
+	tests := []struct {
+		value  string
+		expect bool
+	}{
+		{"true", true},
+		{"1", true},
+		{"yes", true},
+		{"on", true},
+		{"false", false},
+		{"0", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		cfg := testConfig(t, "LOG_HTTP_HEADERS="+tt.value, "LOG_HTTP_BODY="+tt.value)
+		if cfg.LogHTTPHeaders != tt.expect || cfg.LogHTTPBody != tt.expect {
+			t.Errorf("Expected %q to give %t, got %t and %t", tt.value, tt.expect, cfg.LogHTTPHeaders, cfg.LogHTTPBody)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		env    []string
+		expect string
+	}{
+		{"InvalidPort", nil, []string{"PORT=http"}, `invalid PORT "http" (from environment)`},
+		{"InvalidBool", []string{"-send-server-hostname=maybe"}, nil, `invalid SEND_SERVER_HOSTNAME "maybe" (from flag)`},
+		{"InvalidTimeout", nil, []string{"CONNECTION_TIMEOUT_MINUTES=-1"}, "must be a positive number of minutes"},
+		{"InvalidShutdownTimeout", nil, []string{"SHUTDOWN_TIMEOUT_SECONDS=0"}, "must be a positive number of seconds"},
+		{"InvalidSSERetry", nil, []string{"SSE_RETRY_SECONDS=0"}, "must be a positive number of seconds"},
+		{"InvalidRateLimit", nil, []string{"RATE_LIMIT_PER_IP=-1"}, "must be a non-negative number"},
+		{"InvalidCompressionLevel", nil, []string{"WEBSOCKET_COMPRESSION_LEVEL=12"}, "between -2 and 9"},
+		{"InvalidLogLevel", nil, []string{"LOG_LEVEL=verbose"}, "must be debug, info, warn or error"},
+		{"InvalidLogFormat", []string{"-log-format", "xml"}, nil, `invalid LOG_FORMAT "xml" (from flag)`},
+		{"InvalidAccessLog", nil, []string{"ACCESS_LOG=apache"}, "must be off, common, combined or json"},
+		{"AccessLogFileWithoutFormat", nil, []string{"ACCESS_LOG_FILE=access.log"}, "ACCESS_LOG must be set"},
+		{"InvalidTrustedProxies", nil, []string{"TRUSTED_PROXIES=10.0.0.0/33"}, `"10.0.0.0/33" is not an IP address or CIDR range`},
+		{"ProxyProtocolWithoutProxies", nil, []string{"PROXY_PROTOCOL=true"}, "TRUSTED_PROXIES must be set"},
+		{"InvalidRoutePath", nil, []string{"SSE_PATH=events"}, `must be a path starting with "/" or "off"`},
+		{"InvalidPathPrefix", nil, []string{"PATH_PREFIX=echo"}, `must be a path starting with "/"`},
+		{"DuplicateRoutePath", nil, []string{"SSE_PATH=/.ws"}, "the websocket_ui and sse endpoints are both served at /.ws"},
+		{"RouteAtRoot", nil, []string{"METRICS_PATH=/"}, "cannot be served at /"},
+		{"InvalidClientAuth", nil, []string{"TLS_CLIENT_AUTH=sometimes"}, "must be none, request"},
+		{"CertWithoutKey", nil, []string{"TLS_CERT_FILE=cert.pem"}, "must be set together"},
+		{"VerifyWithoutCA", nil, []string{"TLS_CLIENT_AUTH=verify"}, "TLS_CLIENT_CA_FILE must be set"},
+		{"SamePorts", nil, []string{"TLS_SELF_SIGNED=true", "TLS_PORT=8080"}, "must be different"},
+		{"UnknownFlag", []string{"-colour"}, nil, "flag provided but not defined"},
+		{"MissingFile", []string{"-config", "missing.json"}, nil, "unable to read config file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(tt.args, tt.env)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Errorf("Expected error containing %q, got %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+	}{
+		{"JSON", "echo.json", `{"port": 9000, "allowed_origins": ["same-origin", "https://example.com"], "send_header_x_custom": "a b"}`},
+		{"YAML", "echo.yaml", `
+# Echo server
+port: 9000
+allowed_origins:
+  - same-origin
+  - "https://example.com"
+send_header_x_custom: 'a b' # trailing comment
+`},
+		{"YAMLInlineList", "echo.yml", `
+port: "9000"
+allowed_origins: [same-origin, 'https://example.com']
+send_header_x_custom: "a b"
+`},
+		{"TOML", "echo.toml", `
+# Echo server
+port = 9000
+allowed_origins = ["same-origin", "https://example.com"]
+send_header_x_custom = "a b"
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, tt.file, tt.contents)
+
+			cfg, err := loadConfig([]string{"-config", path}, nil)
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+
+			if cfg.Port != "9000" {
+				t.Errorf("Expected port 9000, got %s", cfg.Port)
+			}
+			if !cfg.AllowedOrigins.SameOrigin || strings.Join(cfg.AllowedOrigins.Patterns, ",") != "https://example.com" {
+				t.Errorf("Unexpected origin policy %+v", cfg.AllowedOrigins)
+			}
+			if v := cfg.SendHeaders.Get("X-Custom"); v != "a b" {
+				t.Errorf("Expected X-Custom header 'a b', got %q", v)
+			}
+		})
+	}
+}
+
+func TestReadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+		expect   string
+	}{
+		{"UnknownOption", "echo.json", `{"prot": 8080}`, `unknown option "prot"`},
+		{"Nested", "echo.json", `{"port": {"http": 8080}}`, "must be a scalar or a list"},
+		{"TOMLTable", "echo.toml", "[server]\nport = 8080", "line 1: tables are not supported"},
+		{"YAMLSyntax", "echo.yaml", "port 8080", "line 1: expected key:value"},
+		{"Extension", "echo.ini", "port=8080", "unsupported config file extension"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, tt.file, tt.contents)
+
+			_, err := loadConfig([]string{"-config", path}, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Errorf("Expected error containing %q, got %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestConfigFileFromEnvironment(t *testing.T) {
+	path := writeConfigFile(t, "echo.yaml", "send_server_hostname: false\n")
+
+	cfg := testConfig(t, "CONFIG_FILE="+path)
+	if cfg.SendServerHostname {
+		t.Errorf("Expected SEND_SERVER_HOSTNAME from CONFIG_FILE to be false")
+	}
+	if cfg.File != path {
+		t.Errorf("Expected config file %s to be recorded, got %q", path, cfg.File)
+	}
+}
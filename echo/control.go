@@ -0,0 +1,266 @@
+package echo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxResponseDelay is the longest delay a client may request before the
+	// response is sent.
+	maxResponseDelay = time.Minute
+
+	// maxResponseSize is the largest body size a client may request.
+	maxResponseSize = 10 * 1024 * 1024
+
+	// defaultChunkSize is the size of each chunk when streaming is enabled by
+	// chunk_delay alone.
+	defaultChunkSize = 64
+)
+
+// refusedControlHeaders cannot be set with the header setting. The echo
+// includes content chosen by the client, so letting it change the content
+// type or set cookies would let anyone serve pages from this origin. The
+// framing and hop-by-hop headers belong to the server and proxies.
+var refusedControlHeaders = map[string]bool{
+	"Content-Type":           true,
+	"X-Content-Type-Options": true,
+	"Set-Cookie":             true,
+	"Content-Length":         true,
+	"Connection":             true,
+	"Keep-Alive":             true,
+	"Proxy-Authenticate":     true,
+	"Proxy-Authorization":    true,
+	"Te":                     true,
+	"Trailer":                true,
+	"Transfer-Encoding":      true,
+	"Upgrade":                true,
+}
+
+// responseControl describes how the client asked for the echo response to be
+// shaped. Each setting is read from a query parameter, falling back to the
+// equivalent X-Echo-* request header.
+type responseControl struct {
+	// Status is the HTTP status code of the response.
+	Status int
+
+	// Headers are additional headers to send in the response.
+	Headers http.Header
+
+	// Delay is how long to wait before sending the response headers.
+	Delay time.Duration
+
+	// Jitter is the upper bound of a random duration added to each delay.
+	Jitter time.Duration
+
+	// Size is the minimum size of the response body, in bytes.
+	Size int
+
+	// ChunkSize is the number of bytes written between flushes when the body
+	// is streamed. Zero disables streaming.
+	ChunkSize int
+
+	// ChunkDelay is how long to wait between streamed chunks.
+	ChunkDelay time.Duration
+}
+
+// parseResponseControl reads the response control settings from req.
+//
+// The following query parameters (or X-Echo-<Name> headers) are supported:
+//
+//	status=503            respond with the given status code
+//	header=Name:Value     add a response header, may be repeated, except
+//	                      for those in refusedControlHeaders
+//	delay=500ms           wait before sending the response headers
+//	jitter=100ms          add up to this much random time to each delay
+//	size=1024             pad the response body with spaces to N bytes
+//	chunk_size=16         stream the body in chunks of N bytes
+//	chunk_delay=100ms     stream the body, waiting between chunks
+func parseResponseControl(req *http.Request) (*responseControl, error) {
+	control := &responseControl{
+		Status:  http.StatusOK,
+		Headers: http.Header{},
+	}
+
+	if v := controlValue(req, "status"); v != "" {
+		status, err := strconv.Atoi(v)
+		if err != nil || status < 200 || status > 599 {
+			return nil, fmt.Errorf("invalid status %q: must be between 200 and 599", v)
+		}
+		control.Status = status
+	}
+
+	for _, v := range controlValues(req, "header") {
+		name, value, ok := strings.Cut(v, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header %q: must be in the form Name:Value", v)
+		}
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if refusedControlHeaders[name] {
+			return nil, fmt.Errorf("invalid header %q: %s cannot be set", v, name)
+		}
+		control.Headers.Add(name, strings.TrimSpace(value))
+	}
+
+	var err error
+
+	if control.Delay, err = parseControlDuration(req, "delay"); err != nil {
+		return nil, err
+	}
+
+	if control.Jitter, err = parseControlDuration(req, "jitter"); err != nil {
+		return nil, err
+	}
+
+	if v := controlValue(req, "size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 0 || size > maxResponseSize {
+			return nil, fmt.Errorf("invalid size %q: must be between 0 and %d", v, maxResponseSize)
+		}
+		control.Size = size
+	}
+
+	if control.ChunkDelay, err = parseControlDuration(req, "chunk_delay"); err != nil {
+		return nil, err
+	}
+
+	if v := controlValue(req, "chunk_size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 || size > maxResponseSize {
+			return nil, fmt.Errorf("invalid chunk_size %q: must be between 1 and %d", v, maxResponseSize)
+		}
+		control.ChunkSize = size
+	} else if control.ChunkDelay > 0 {
+		control.ChunkSize = defaultChunkSize
+	}
+
+	return control, nil
+}
+
+// controlValues returns the values of the query parameter name, or of the
+// X-Echo-<name> request header if the query parameter is absent.
+func controlValues(req *http.Request, name string) []string {
+	if values, ok := req.URL.Query()[name]; ok {
+		return values
+	}
+
+	return req.Header.Values(textproto.CanonicalMIMEHeaderKey("X-Echo-" + name))
+}
+
+// controlValue returns the first value of the control setting name.
+func controlValue(req *http.Request, name string) string {
+	if values := controlValues(req, name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// parseControlDuration parses the control setting name as a duration. Plain
+// integers are interpreted as milliseconds.
+func parseControlDuration(req *http.Request, name string) (time.Duration, error) {
+	v := controlValue(req, name)
+	if v == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		ms, msErr := strconv.Atoi(v)
+		if msErr != nil {
+			return 0, fmt.Errorf("invalid %s %q: must be a duration such as 500ms", name, v)
+		}
+		d = time.Duration(ms) * time.Millisecond
+	}
+
+	if d < 0 || d > maxResponseDelay {
+		return 0, fmt.Errorf("invalid %s %q: must be between 0 and %s", name, v, maxResponseDelay)
+	}
+
+	return d, nil
+}
+
+// jittered returns d plus a random duration of up to c.Jitter.
+func (c *responseControl) jittered(d time.Duration) time.Duration {
+	if c.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(c.Jitter)))
+	}
+	return d
+}
+
+// slow returns true if the response is delayed or streamed with a delay
+// between chunks, including a delay added by the jitter alone.
+func (c *responseControl) slow() bool {
+	return c.Delay > 0 || c.ChunkDelay > 0 || c.Jitter > 0
+}
+
+// wait blocks for the configured delay. It returns false if ctx is canceled
+// first.
+func (c *responseControl) wait(ctx context.Context) bool {
+	return sleep(ctx, c.jittered(c.Delay))
+}
+
+// pad appends spaces to body until it is at least c.Size bytes long.
+func (c *responseControl) pad(body *bytes.Buffer) {
+	if n := c.Size - body.Len(); n > 0 {
+		body.Write(bytes.Repeat([]byte{' '}, n))
+	}
+}
+
+// writeHeaders adds the requested response headers to h, replacing any
+// existing values.
+func (c *responseControl) writeHeaders(h http.Header) {
+	for name, values := range c.Headers {
+		h[name] = values
+	}
+}
+
+// writeBody writes body to wr. When streaming is enabled the body is written
+// in chunks, flushing after each one so that it is sent using chunked transfer
+// encoding (or separate DATA frames over HTTP/2). Streaming stops early if ctx
+// is canceled.
+func (c *responseControl) writeBody(ctx context.Context, wr http.ResponseWriter, body []byte) {
+	flusher, ok := wr.(http.Flusher)
+	if c.ChunkSize == 0 || !ok {
+		wr.Write(body) // nolint:errcheck
+		return
+	}
+
+	for len(body) > 0 {
+		n := min(c.ChunkSize, len(body))
+
+		if _, err := wr.Write(body[:n]); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		body = body[n:]
+		if len(body) > 0 && !sleep(ctx, c.jittered(c.ChunkDelay)) {
+			return
+		}
+	}
+}
+
+// sleep blocks for d. It returns false if ctx is canceled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
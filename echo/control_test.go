@@ -0,0 +1,306 @@
+package echo
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseResponseControl(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		headers   map[string]string
+		expectErr bool
+		check     func(t *testing.T, c *responseControl)
+	}{
+		{
+			name:   "Defaults",
+			target: "/",
+			check: func(t *testing.T, c *responseControl) {
+				if c.Status != 200 || c.Delay != 0 || c.Jitter != 0 || c.Size != 0 || len(c.Headers) != 0 {
+					t.Errorf("Unexpected defaults: %+v", c)
+				}
+			},
+		},
+		{
+			name:   "Query",
+			target: "/?status=503&delay=250ms&jitter=10&size=64&header=Retry-After:5&header=X-A:%20b",
+			check: func(t *testing.T, c *responseControl) {
+				if c.Status != 503 {
+					t.Errorf("Expected status 503, got %d", c.Status)
+				}
+				if c.Delay != 250*time.Millisecond {
+					t.Errorf("Expected delay 250ms, got %s", c.Delay)
+				}
+				if c.Jitter != 10*time.Millisecond {
+					t.Errorf("Expected jitter 10ms, got %s", c.Jitter)
+				}
+				if c.Size != 64 {
+					t.Errorf("Expected size 64, got %d", c.Size)
+				}
+				if c.Headers.Get("Retry-After") != "5" || c.Headers.Get("X-A") != "b" {
+					t.Errorf("Unexpected headers: %v", c.Headers)
+				}
+			},
+		},
+		{
+			name:    "Headers",
+			target:  "/",
+			headers: map[string]string{"X-Echo-Status": "418", "X-Echo-Header": "X-Teapot: yes"},
+			check: func(t *testing.T, c *responseControl) {
+				if c.Status != 418 {
+					t.Errorf("Expected status 418, got %d", c.Status)
+				}
+				if c.Headers.Get("X-Teapot") != "yes" {
+					t.Errorf("Unexpected headers: %v", c.Headers)
+				}
+			},
+		},
+		{
+			name:    "QueryOverridesHeader",
+			target:  "/?status=201",
+			headers: map[string]string{"X-Echo-Status": "418"},
+			check: func(t *testing.T, c *responseControl) {
+				if c.Status != 201 {
+					t.Errorf("Expected status 201, got %d", c.Status)
+				}
+			},
+		},
+		{name: "InvalidStatus", target: "/?status=99", expectErr: true},
+		{name: "InvalidHeader", target: "/?header=nocolon", expectErr: true},
+		{name: "RefusedContentType", target: "/?header=content-type:text/html", expectErr: true},
+		{name: "RefusedSetCookie", target: "/?header=Set-Cookie:a=b", expectErr: true},
+		{name: "RefusedHopByHop", headers: map[string]string{"X-Echo-Header": "Transfer-Encoding: identity"}, target: "/", expectErr: true},
+		{name: "InvalidDelay", target: "/?delay=soon", expectErr: true},
+		{name: "DelayTooLong", target: "/?delay=1h", expectErr: true},
+		{name: "SizeTooLarge", target: "/?size=999999999", expectErr: true},
+		{name: "InvalidChunkSize", target: "/?chunk_size=0", expectErr: true},
+		{
+			name:   "ChunkDelayOnly",
+			target: "/?chunk_delay=10ms",
+			check: func(t *testing.T, c *responseControl) {
+				if c.ChunkSize != defaultChunkSize || c.ChunkDelay != 10*time.Millisecond {
+					t.Errorf("Expected default chunk size with 10ms delay, got %+v", c)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			control, err := parseResponseControl(req)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			tt.check(t, control)
+		})
+	}
+}
+
+func TestHTTPResponseControl(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/?status=503&header=Retry-After:7&delay=200ms&size=4096")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected response to be delayed by at least 200ms, got %s", elapsed)
+	}
+	if resp.StatusCode != 503 {
+		t.Errorf("Expected status 503, got %d", resp.StatusCode)
+	}
+	if v := resp.Header.Get("Retry-After"); v != "7" {
+		t.Errorf("Expected Retry-After 7, got %q", v)
+	}
+	if v := resp.Header.Get("X-Content-Type-Options"); v != "nosniff" {
+		t.Errorf("Expected X-Content-Type-Options nosniff, got %q", v)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if len(body) != 4096 {
+		t.Errorf("Expected a 4096 byte body, got %d", len(body))
+	}
+	if !strings.Contains(string(body), "GET /?status=503") {
+		t.Errorf("Response doesn't contain echoed request line")
+	}
+}
+
+func TestHTTPResponseControlInvalid(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/?status=abc")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", resp.StatusCode)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "invalid status") {
+		t.Errorf("Expected an explanatory error, got %q", body)
+	}
+}
+
+func TestHTTPResponseControlRefusedHeaders(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	for _, header := range []string{"Content-Type:text/html", "Set-Cookie:session=x", "Connection:close"} {
+		resp, err := http.Get(server.URL + "/?header=" + url.QueryEscape(header) + "&x=%3Cscript%3Ealert(1)%3C/script%3E")
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected %s to be refused, got %d", header, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); strings.Contains(ct, "html") || resp.Header.Get("Set-Cookie") != "" {
+			t.Errorf("Expected %s not to be sent, got %v", header, resp.Header)
+		}
+	}
+}
+
+func TestHTTPChunkedStreaming(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/?size=1200&chunk_size=512&chunk_delay=100ms")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("Expected chunked transfer encoding, got %v", resp.TransferEncoding)
+	}
+
+	// The first chunk should arrive on its own, before the delayed chunks.
+	first := make([]byte, 1024)
+	n, err := resp.Body.Read(first)
+	if err != nil {
+		t.Fatalf("Failed to read first chunk: %v", err)
+	}
+	if n != 512 {
+		t.Errorf("Expected first read to return one 512 byte chunk, got %d bytes", n)
+	}
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Errorf("Expected first chunk before the chunk delay, got it after %s", elapsed)
+	}
+
+	rest, _ := io.ReadAll(resp.Body)
+	if total := n + len(rest); total != 1200 {
+		t.Errorf("Expected a 1200 byte body, got %d", total)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected streaming to take at least 200ms, got %s", elapsed)
+	}
+}
+
+func TestHTTPStreamingTimeout(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "CONNECTION_TIMEOUT_MINUTES=0.005"))) // 300ms
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/?size=1000&chunk_size=1&chunk_delay=1m")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the response to end at the connection timeout, took %s", elapsed)
+	}
+	if len(body) != 1 {
+		t.Errorf("Expected the body to be cut short after the first chunk, got %d bytes", len(body))
+	}
+}
+
+func TestHTTPStreamingConnectionLimit(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "MAX_CONNECTIONS_PER_IP=1")))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/?chunk_size=1&chunk_delay=100ms")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	second, err := http.Get(server.URL + "/?delay=10ms")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	second.Body.Close()
+	if second.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected a second slow response to be rejected, got %d", second.StatusCode)
+	}
+
+	fast, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	fast.Body.Close()
+	if fast.StatusCode != http.StatusOK {
+		t.Errorf("Expected an immediate response to be served, got %d", fast.StatusCode)
+	}
+}
+
+func TestHTTPJitterConnectionLimit(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "MAX_CONNECTIONS=1")))
+	defer server.Close()
+
+	// Hold the only connection with a delayed response.
+	held := make(chan struct{})
+	go func() {
+		defer close(held)
+		if resp, err := http.Get(server.URL + "/?delay=500ms"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	for _, query := range []string{"jitter=1s", "chunk_size=1&jitter=1s"} {
+		resp, err := http.Get(server.URL + "/?" + query)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("Expected %s to count as a connection and be rejected, got %d", query, resp.StatusCode)
+		}
+	}
+
+	<-held
+}
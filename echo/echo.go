@@ -0,0 +1,208 @@
+// Package echo implements the echo server behind echo.websocket.org, which
+// echoes HTTP requests, WebSocket messages and Server-Sent Events back to the
+// client.
+//
+// New returns the server as an http.Handler, so that it can be embedded in
+// other servers:
+//
+//	handler, err := echo.New(echo.Options{Subprotocols: []string{"chat"}})
+//	if err != nil {
+//		return err
+//	}
+//	mux.Handle("/echo/", http.StripPrefix("/echo", handler))
+//
+// Package echotest starts one on a test server. The echo-server command is a
+// thin wrapper around Run.
+package echo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options configures a Handler. The zero value gives the defaults of
+// echo-server.
+type Options struct {
+	// Logger receives the server's logs. They are discarded if it is nil.
+	Logger *slog.Logger
+
+	// InstanceID identifies the server in responses, instead of the hostname.
+	InstanceID string
+
+	// HideServedBy stops the server from identifying itself in responses,
+	// unless a request asks for it with X-Send-Server-Hostname.
+	HideServedBy bool
+
+	// SendHeaders are added to every response.
+	SendHeaders http.Header
+
+	// ConnectionTimeout is the longest a WebSocket or SSE connection may stay
+	// open. It defaults to 10 minutes.
+	ConnectionTimeout time.Duration
+
+	// Subprotocols are the WebSocket subprotocols to accept, or "*" to accept
+	// the first one offered. They default to echo.json.
+	Subprotocols []string
+
+	// AllowedOrigins are the origins allowed to open WebSockets, in the forms
+	// accepted by ALLOWED_ORIGINS. Any origin is allowed if it is empty.
+	AllowedOrigins []string
+
+	// TrustedProxies are the IP addresses, CIDR ranges and named ranges whose
+	// forwarding headers are believed, as for TRUSTED_PROXIES.
+	TrustedProxies []string
+
+	// MaxConnections and MaxConnectionsPerIP limit the open WebSocket and SSE
+	// connections, and RateLimit and RateLimitPerIP the requests per second.
+	// Zero values are unlimited.
+	MaxConnections      int
+	MaxConnectionsPerIP int
+	RateLimit           float64
+	RateLimitPerIP      float64
+
+	// Settings sets any other echo-server option by its environment variable
+	// name, e.g. "WEBSOCKET_MAX_MESSAGE_SIZE": "1024". They are applied after
+	// the fields above. The options for the listeners, TLS, logging and
+	// shutdown of echo-server are not accepted, as they are up to the server
+	// the handler is used by.
+	Settings map[string]string
+}
+
+// serverOptions are the echo-server options used by Run rather than the
+// handler, which Options.Settings rejects.
+var serverOptions = map[string]bool{
+	"PORT":                     true,
+	"TLS_PORT":                 true,
+	"TLS_CERT_FILE":            true,
+	"TLS_KEY_FILE":             true,
+	"TLS_SELF_SIGNED":          true,
+	"TLS_SELF_SIGNED_HOSTS":    true,
+	"TLS_CLIENT_AUTH":          true,
+	"TLS_CLIENT_CA_FILE":       true,
+	"LOG_LEVEL":                true,
+	"LOG_FORMAT":               true,
+	"ACCESS_LOG":               true,
+	"ACCESS_LOG_FILE":          true,
+	"ACCESS_LOG_MAX_SIZE":      true,
+	"ACCESS_LOG_MAX_BACKUPS":   true,
+	"ACCESS_LOG_PROBES":        true,
+	"SHUTDOWN_TIMEOUT_SECONDS": true,
+	"PROXY_PROTOCOL":           true,
+}
+
+// config converts the options to the configuration used by echo-server,
+// validating them in the same way.
+func (o Options) config() (*config, error) {
+	c := defaultConfig()
+
+	values := map[string]string{}
+	if o.InstanceID != "" {
+		values["INSTANCE_ID"] = o.InstanceID
+	}
+	if o.HideServedBy {
+		values["SEND_SERVER_HOSTNAME"] = "false"
+	}
+	if o.ConnectionTimeout != 0 {
+		values["CONNECTION_TIMEOUT_MINUTES"] = strconv.FormatFloat(o.ConnectionTimeout.Minutes(), 'f', -1, 64)
+	}
+	if o.Subprotocols != nil {
+		values["WEBSOCKET_SUBPROTOCOLS"] = strings.Join(o.Subprotocols, ",")
+	}
+	if len(o.AllowedOrigins) > 0 {
+		values["ALLOWED_ORIGINS"] = strings.Join(o.AllowedOrigins, ",")
+	}
+	if len(o.TrustedProxies) > 0 {
+		values["TRUSTED_PROXIES"] = strings.Join(o.TrustedProxies, ",")
+	}
+	if o.MaxConnections != 0 {
+		values["MAX_CONNECTIONS"] = strconv.Itoa(o.MaxConnections)
+	}
+	if o.MaxConnectionsPerIP != 0 {
+		values["MAX_CONNECTIONS_PER_IP"] = strconv.Itoa(o.MaxConnectionsPerIP)
+	}
+	if o.RateLimit != 0 {
+		values["RATE_LIMIT"] = strconv.FormatFloat(o.RateLimit, 'f', -1, 64)
+	}
+	if o.RateLimitPerIP != 0 {
+		values["RATE_LIMIT_PER_IP"] = strconv.FormatFloat(o.RateLimitPerIP, 'f', -1, 64)
+	}
+
+	if err := c.apply(values, "options"); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range o.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !isConfigOption(name) {
+			return nil, fmt.Errorf("unknown option %q", name)
+		}
+		if serverOptions[name] {
+			return nil, fmt.Errorf("option %q is only used by the echo-server command", name)
+		}
+	}
+
+	if err := c.apply(o.Settings, "settings"); err != nil {
+		return nil, err
+	}
+
+	for name, values := range o.SendHeaders {
+		c.SendHeaders[http.CanonicalHeaderKey(name)] = values
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	c.Instance = resolveInstance(c.InstanceID, c.InstanceMetadata, environMap(os.Environ()))
+
+	return c, nil
+}
+
+// New returns a handler that serves the echo endpoints with opts, or an
+// error if opts is invalid.
+//
+// Call Shutdown on the handler before closing the server it is used by, as
+// WebSocket and SSE connections stay open until they time out.
+func New(opts Options) (*Handler, error) {
+	cfg, err := opts.config()
+	if err != nil {
+		return nil, fmt.Errorf("echo: invalid options: %w", err)
+	}
+
+	h := newHandler(cfg)
+	h.logger = opts.Logger
+	if h.logger == nil {
+		h.logger = discardLogger()
+	}
+
+	return h, nil
+}
+
+// MustNew is like New, but panics if opts is invalid. It is intended for
+// options that are fixed in the code.
+func MustNew(opts Options) *Handler {
+	h, err := New(opts)
+	if err != nil {
+		panic(err.Error())
+	}
+	return h
+}
+
+// Shutdown asks open WebSocket connections and SSE streams to close, and
+// waits for the WebSocket connections to close or ctx to be done. The
+// readiness endpoint fails from when it is called.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	h.shutdown()
+	return h.drain(ctx)
+}
@@ -1,4 +1,4 @@
-package main
+package echo
 
 import (
 	"bufio"
@@ -13,7 +13,7 @@ import (
 
 // TestWebSocketBasicEcho tests the core WebSocket echo functionality
 func TestWebSocketBasicEcho(t *testing.T) {
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t))
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -73,7 +73,7 @@ func TestWebSocketBasicEcho(t *testing.T) {
 
 // TestWebSocketMultipleClients tests echo with multiple concurrent clients
 func TestWebSocketMultipleClients(t *testing.T) {
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t))
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -125,7 +125,7 @@ func TestWebSocketMultipleClients(t *testing.T) {
 
 // TestSSEBasicStream tests the core SSE functionality
 func TestSSEBasicStream(t *testing.T) {
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t))
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -194,7 +194,7 @@ func TestSSEBasicStream(t *testing.T) {
 
 // TestSSEMultipleClients tests SSE with multiple concurrent clients
 func TestSSEMultipleClients(t *testing.T) {
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t))
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -248,7 +248,7 @@ func TestSSEMultipleClients(t *testing.T) {
 
 // TestWebSocketRapidMessages tests handling of rapid message sending
 func TestWebSocketRapidMessages(t *testing.T) {
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t))
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -296,7 +296,7 @@ func TestWebSocketRapidMessages(t *testing.T) {
 
 // TestWebSocketLargeMessage tests handling of large messages
 func TestWebSocketLargeMessage(t *testing.T) {
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t))
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
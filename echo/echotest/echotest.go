@@ -0,0 +1,40 @@
+// Package echotest starts the echo server for tests:
+//
+//	server := echotest.NewServer(t, echo.Options{Subprotocols: []string{"chat"}})
+//	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+package echotest
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ably/echo.websocket.org/echo"
+)
+
+// shutdownTimeout is how long a server waits for its WebSocket connections
+// to close when the test ends.
+const shutdownTimeout = 5 * time.Second
+
+// NewServer starts a server that serves the echo endpoints with opts. It
+// fails the test if opts is invalid, and is shut down when the test ends.
+func NewServer(t testing.TB, opts echo.Options) *httptest.Server {
+	t.Helper()
+
+	h, err := echo.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(h)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		h.Shutdown(ctx) // nolint:errcheck
+		server.Close()
+	})
+
+	return server
+}
@@ -0,0 +1,58 @@
+package echotest_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ably/echo.websocket.org/echo"
+	"github.com/ably/echo.websocket.org/echo/echotest"
+	"github.com/gorilla/websocket"
+)
+
+func TestNewServer(t *testing.T) {
+	server := echotest.NewServer(t, echo.Options{
+		InstanceID:   "test-1",
+		Subprotocols: []string{"chat"},
+		SendHeaders:  http.Header{"X-Test": {"yes"}},
+		Settings:     map[string]string{"WEBSOCKET_MAX_MESSAGE_SIZE": "16"},
+	})
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.Header.Get("X-Test") != "yes" || resp.Header.Get("X-Served-By") != "test-1" {
+		t.Errorf("Expected the configured headers, got %v", resp.Header)
+	}
+	if !strings.Contains(string(body), "GET / HTTP/1.1") {
+		t.Errorf("Expected the request to be echoed, got %q", body)
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{"chat"}}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer ws.Close()
+
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, greeting, err := ws.ReadMessage(); err != nil || !strings.Contains(string(greeting), "Max message size: 16") {
+		t.Fatalf("Unexpected greeting %q: %v", greeting, err)
+	}
+	if ws.Subprotocol() != "chat" {
+		t.Errorf("Expected the chat subprotocol, got %q", ws.Subprotocol())
+	}
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if _, msg, err := ws.ReadMessage(); err != nil || string(msg) != "hello" {
+		t.Errorf("Expected the message to be echoed, got %q: %v", msg, err)
+	}
+}
@@ -0,0 +1,327 @@
+package echo
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// echoFormat is the representation used to echo a request back to the client.
+type echoFormat int
+
+const (
+	formatText echoFormat = iota
+	formatJSON
+	formatYAML
+)
+
+// contentType returns the media type used when the format is sent as an HTTP
+// response body.
+func (f echoFormat) contentType() string {
+	switch f {
+	case formatJSON:
+		return "application/json"
+	case formatYAML:
+		return "application/yaml"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// negotiateFormat selects the echo format for req. The "format" query
+// parameter takes precedence over the Accept header.
+func negotiateFormat(req *http.Request) echoFormat {
+	if v := req.URL.Query().Get("format"); v != "" {
+		switch strings.ToLower(v) {
+		case "json":
+			return formatJSON
+		case "yaml", "yml":
+			return formatYAML
+		default:
+			return formatText
+		}
+	}
+
+	for _, accept := range req.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || params["q"] == "0" {
+				continue
+			}
+
+			switch mediaType {
+			case "application/json":
+				return formatJSON
+			case "application/yaml", "application/x-yaml", "text/yaml":
+				return formatYAML
+			case "text/plain", "text/*", "*/*":
+				return formatText
+			}
+		}
+	}
+
+	return formatText
+}
+
+// echoedRequest is the machine-readable representation of a request, shared
+// by every transport.
+type echoedRequest struct {
+	ServedBy   string            `json:"served_by,omitempty"`
+	Instance   map[string]string `json:"instance_metadata,omitempty"`
+	Method     string            `json:"method"`
+	URL        echoedURL         `json:"url"`
+	Proto      string            `json:"proto"`
+	Host       string            `json:"host"`
+	Headers    http.Header       `json:"headers"`
+	Query      url.Values        `json:"query"`
+	Body       string            `json:"body"`
+	BodyBase64 bool              `json:"body_base64,omitempty"`
+	RemoteAddr string            `json:"remote_addr"`
+	ClientAddr string            `json:"client_addr"`
+	Proxies    []string          `json:"proxies,omitempty"`
+	TLS        *echoedTLS        `json:"tls,omitempty"`
+	WebSocket  *echoedWebSocket  `json:"websocket,omitempty"`
+	SSE        *echoedSSE        `json:"sse,omitempty"`
+}
+
+// echoedURL describes the components of the request URL.
+type echoedURL struct {
+	Raw      string `json:"raw"`
+	Scheme   string `json:"scheme"`
+	Host     string `json:"host"`
+	Path     string `json:"path"`
+	RawQuery string `json:"raw_query,omitempty"`
+	Fragment string `json:"fragment,omitempty"`
+}
+
+// echoedTLS describes the TLS connection the request arrived on.
+type echoedTLS struct {
+	Version            string              `json:"version"`
+	CipherSuite        string              `json:"cipher_suite"`
+	ServerName         string              `json:"server_name,omitempty"`
+	NegotiatedProtocol string              `json:"negotiated_protocol,omitempty"`
+	Resumed            bool                `json:"resumed"`
+	ClientVerified     bool                `json:"client_verified,omitempty"`
+	ClientCertificates []echoedCertificate `json:"client_certificates,omitempty"`
+}
+
+// echoedCertificate describes a certificate presented by the client.
+type echoedCertificate struct {
+	Subject           string    `json:"subject"`
+	Issuer            string    `json:"issuer"`
+	SerialNumber      string    `json:"serial_number"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+	SANs              []string  `json:"sans,omitempty"`
+	SHA256Fingerprint string    `json:"sha256_fingerprint"`
+}
+
+// newEchoedRequest builds the structured echo of req, consuming its body.
+// Bodies that are not valid UTF-8 are base64 encoded.
+func newEchoedRequest(req *http.Request) *echoedRequest {
+	var body bytes.Buffer
+	io.Copy(&body, req.Body) // nolint:errcheck
+
+	client := requestClient(req)
+
+	echo := &echoedRequest{
+		Method: req.Method,
+		URL: echoedURL{
+			Raw:      req.URL.String(),
+			Scheme:   requestScheme(req),
+			Host:     req.Host,
+			Path:     req.URL.Path,
+			RawQuery: req.URL.RawQuery,
+			Fragment: req.URL.Fragment,
+		},
+		Proto:      req.Proto,
+		Host:       req.Host,
+		Headers:    req.Header,
+		Query:      req.URL.Query(),
+		RemoteAddr: client.Peer,
+		ClientAddr: client.Addr,
+		Proxies:    client.Proxies,
+	}
+
+	if utf8.Valid(body.Bytes()) {
+		echo.Body = body.String()
+	} else {
+		echo.Body = base64.StdEncoding.EncodeToString(body.Bytes())
+		echo.BodyBase64 = true
+	}
+
+	if req.TLS != nil {
+		echo.TLS = newEchoedTLS(req.TLS)
+	}
+
+	return echo
+}
+
+// setServedBy identifies the instance that served the request, unless
+// servedBy is nil.
+func (e *echoedRequest) setServedBy(servedBy *instanceIdentity) {
+	if servedBy != nil {
+		e.ServedBy = servedBy.ID
+		e.Instance = servedBy.Metadata
+	}
+}
+
+// newEchoedTLS describes the negotiated parameters of a TLS connection,
+// including the certificate chain presented by the client, if any.
+func newEchoedTLS(state *tls.ConnectionState) *echoedTLS {
+	info := &echoedTLS{
+		Version:            tls.VersionName(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		ServerName:         state.ServerName,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		Resumed:            state.DidResume,
+		ClientVerified:     len(state.VerifiedChains) > 0,
+	}
+
+	for _, cert := range state.PeerCertificates {
+		info.ClientCertificates = append(info.ClientCertificates, echoedCertificate{
+			Subject:           cert.Subject.String(),
+			Issuer:            cert.Issuer.String(),
+			SerialNumber:      cert.SerialNumber.String(),
+			NotBefore:         cert.NotBefore,
+			NotAfter:          cert.NotAfter,
+			SANs:              certificateSANs(cert),
+			SHA256Fingerprint: certificateFingerprint(cert.Raw),
+		})
+	}
+
+	return info
+}
+
+// marshalEcho encodes echo in the given structured format. JSON is indented
+// when pretty is true, otherwise it is written on a single line so that it
+// fits in one SSE data field or WebSocket message.
+func marshalEcho(format echoFormat, echo *echoedRequest, pretty bool) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	if pretty && format == formatJSON {
+		data, err = json.MarshalIndent(echo, "", "  ")
+	} else {
+		data, err = json.Marshal(echo)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if format == formatYAML {
+		return jsonToYAML(data)
+	}
+
+	return data, nil
+}
+
+// plainYAMLKey matches mapping keys that can be written without quotes.
+var plainYAMLKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// jsonToYAML re-encodes a JSON document as block-style YAML, preserving the
+// order of object keys. Strings are written as double-quoted scalars, which
+// share JSON's escape sequences.
+func jsonToYAML(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeYAMLValue(&buf, dec, tok, 0); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimLeft(buf.Bytes(), " \n"), nil
+}
+
+// writeYAMLValue writes the value that begins with tok. Collections start on
+// a new line indented by indent spaces; scalars follow on the current line.
+func writeYAMLValue(buf *bytes.Buffer, dec *json.Decoder, tok json.Token, indent int) error {
+	switch tok {
+	case json.Delim('{'), json.Delim('['):
+		isObject := tok == json.Delim('{')
+
+		if !dec.More() {
+			if isObject {
+				buf.WriteString(" {}\n")
+			} else {
+				buf.WriteString(" []\n")
+			}
+			_, err := dec.Token()
+			return err
+		}
+
+		buf.WriteByte('\n')
+
+		for dec.More() {
+			buf.WriteString(strings.Repeat(" ", indent))
+
+			if isObject {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				buf.WriteString(yamlKey(key.(string)))
+				buf.WriteByte(':')
+			} else {
+				buf.WriteByte('-')
+			}
+
+			next, err := dec.Token()
+			if err != nil {
+				return err
+			}
+
+			if err := writeYAMLValue(buf, dec, next, indent+2); err != nil {
+				return err
+			}
+		}
+
+		_, err := dec.Token()
+		return err
+
+	case nil:
+		buf.WriteString(" null\n")
+
+	default:
+		scalar, err := json.Marshal(tok)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, " %s\n", scalar)
+	}
+
+	return nil
+}
+
+// yamlKey returns k formatted as a YAML mapping key.
+func yamlKey(k string) string {
+	switch strings.ToLower(k) {
+	case "true", "false", "yes", "no", "on", "off", "null":
+	default:
+		if plainYAMLKey.MatchString(k) {
+			return k
+		}
+	}
+
+	quoted, _ := json.Marshal(k)
+	return string(quoted)
+}
@@ -0,0 +1,245 @@
+package echo
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		expect echoFormat
+	}{
+		{"Default", "/", "", formatText},
+		{"QueryJSON", "/?format=json", "", formatJSON},
+		{"QueryYAML", "/?format=yaml", "", formatYAML},
+		{"QueryOverridesAccept", "/?format=text", "application/json", formatText},
+		{"AcceptJSON", "/", "application/json", formatJSON},
+		{"AcceptYAML", "/", "application/yaml", formatYAML},
+		{"AcceptBrowser", "/", "text/html,application/xhtml+xml,*/*;q=0.8", formatText},
+		{"AcceptRejected", "/", "application/json;q=0, text/plain", formatText},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			if got := negotiateFormat(req); got != tt.expect {
+				t.Errorf("Expected format %d, got %d", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestHTTPEchoJSON(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+"/path?b=2&a=1&a=3", strings.NewReader("hello"))
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Test", "value")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type 'application/json', got '%s'", ct)
+	}
+
+	var echo echoedRequest
+	if err := json.NewDecoder(resp.Body).Decode(&echo); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if echo.Method != "POST" {
+		t.Errorf("Expected method POST, got %s", echo.Method)
+	}
+	if echo.URL.Path != "/path" {
+		t.Errorf("Expected path /path, got %s", echo.URL.Path)
+	}
+	if echo.URL.Scheme != "http" {
+		t.Errorf("Expected scheme http, got %s", echo.URL.Scheme)
+	}
+	if got := echo.Query["a"]; len(got) != 2 || got[0] != "1" || got[1] != "3" {
+		t.Errorf("Expected query a=[1 3], got %v", got)
+	}
+	if echo.Headers.Get("X-Test") != "value" {
+		t.Errorf("Expected X-Test header to be echoed, got %v", echo.Headers)
+	}
+	if echo.Body != "hello" || echo.BodyBase64 {
+		t.Errorf("Expected plain body 'hello', got '%s' (base64=%v)", echo.Body, echo.BodyBase64)
+	}
+	if echo.ServedBy == "" {
+		t.Errorf("Expected served_by to be set")
+	}
+	if echo.TLS != nil {
+		t.Errorf("Expected no TLS information, got %+v", echo.TLS)
+	}
+}
+
+func TestHTTPEchoJSONBinaryBody(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	body := []byte{0xff, 0xfe, 0x00, 0x01}
+	resp, err := http.Post(server.URL+"/?format=json", "application/octet-stream", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var echo echoedRequest
+	if err := json.NewDecoder(resp.Body).Decode(&echo); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if !echo.BodyBase64 {
+		t.Fatalf("Expected body to be base64 encoded")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(echo.Body)
+	if err != nil || string(decoded) != string(body) {
+		t.Errorf("Expected decoded body %v, got %v (%v)", body, decoded, err)
+	}
+}
+
+func TestHTTPEchoYAML(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/yaml?format=yaml")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/yaml" {
+		t.Errorf("Expected Content-Type 'application/yaml', got '%s'", ct)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	bodyStr := string(body)
+
+	for _, expect := range []string{
+		"method: \"GET\"\n",
+		"url:\n  raw: \"/yaml?format=yaml\"\n",
+		"query:\n  format:\n    - \"yaml\"\n",
+	} {
+		if !strings.Contains(bodyStr, expect) {
+			t.Errorf("YAML response does not contain %q:\n%s", expect, bodyStr)
+		}
+	}
+
+	if strings.Contains(bodyStr, "WebSocket UI:") {
+		t.Errorf("Structured response should not contain the footer")
+	}
+}
+
+func TestJSONToYAML(t *testing.T) {
+	out, err := jsonToYAML([]byte(`{"b":1,"a":{"true":null,"x y":[]},"c":[{"d":"e"}],"f":{}}`))
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+
+	expect := "b: 1\n" +
+		"a:\n" +
+		"  \"true\": null\n" +
+		"  \"x y\": []\n" +
+		"c:\n" +
+		"  -\n" +
+		"    d: \"e\"\n" +
+		"f: {}\n"
+
+	if string(out) != expect {
+		t.Errorf("Unexpected YAML:\n%s\nexpected:\n%s", out, expect)
+	}
+}
+
+func TestSSERequestEventJSON(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/.sse?format=json")
+	if err != nil {
+		t.Fatalf("Failed to connect to SSE: %v", err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	var event string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read SSE stream: %v", err)
+		}
+
+		line = strings.TrimSpace(line)
+		if v, ok := strings.CutPrefix(line, "event: "); ok {
+			event = v
+		} else if v, ok := strings.CutPrefix(line, "data: "); ok && event == "request" {
+			var echo echoedRequest
+			if err := json.Unmarshal([]byte(v), &echo); err != nil {
+				t.Fatalf("Failed to decode request event: %v", err)
+			}
+			if echo.URL.Path != "/.sse" {
+				t.Errorf("Expected path /.sse, got %s", echo.URL.Path)
+			}
+			return
+		}
+	}
+}
+
+func TestWebSocketGreetingJSON(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/?format=json"
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	defer ws.Close()
+
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read greeting: %v", err)
+	}
+
+	var echo echoedRequest
+	if err := json.Unmarshal(msg, &echo); err != nil {
+		t.Fatalf("Failed to decode greeting: %v", err)
+	}
+
+	if echo.Method != "GET" {
+		t.Errorf("Expected method GET, got %s", echo.Method)
+	}
+	if echo.Headers.Get("Upgrade") != "websocket" {
+		t.Errorf("Expected Upgrade header to be echoed, got %v", echo.Headers)
+	}
+	if echo.ServedBy == "" {
+		t.Errorf("Expected served_by to be set")
+	}
+}
@@ -1,4 +1,19 @@
-package main
+package echo
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// websocketPathPlaceholder is replaced by the quoted path that the test page
+// connects to.
+const websocketPathPlaceholder = "'{{websocket_path}}'"
+
+// websocketPage returns the WebSocket test page, connecting to path.
+func websocketPage(path string) string {
+	quoted, _ := json.Marshal(path)
+	return strings.Replace(websocketHTML, websocketPathPlaceholder, string(quoted), 1)
+}
 
 var websocketHTML = `
 <html>
@@ -128,6 +143,7 @@ var websocketHTML = `
         <div id="console" />
         <script>
             var ws
+            var websocketPath = '{{websocket_path}}'
             var messageDelay = 1500
             var connectDelay = 5000
             var autoReconnect = true;
@@ -167,9 +183,9 @@ var websocketHTML = `
                 cancelBtn.className = '';
 
                 ws = new WebSocket(
-                    location.protocol === 'https:'
+                    (location.protocol === 'https:'
                         ? 'wss://' + window.location.host
-                        : 'ws://' + window.location.host
+                        : 'ws://' + window.location.host) + websocketPath
                 );
 
                 ws.onopen = function (ev) {
@@ -1,4 +1,4 @@
-package main
+package echo
 
 import (
 	"net/http"
@@ -8,7 +8,7 @@ import (
 )
 
 func TestFrontendTimeoutHandling(t *testing.T) {
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t))
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -0,0 +1,67 @@
+package echo
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// isProbeRoute returns true for the health, readiness and version endpoints.
+// The health endpoint reports that the server is alive, the readiness
+// endpoint whether it is accepting new connections, which it stops doing once
+// the server starts shutting down, and the version endpoint the build of the
+// running server and its uptime.
+func isProbeRoute(route string) bool {
+	return route == routeHealth || route == routeReady || route == routeVersion
+}
+
+// versionResponse is the body of the version endpoint.
+type versionResponse struct {
+	versionInfo
+	Uptime        string  `json:"uptime"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+}
+
+// setReady sets whether the readiness endpoint reports the server as ready.
+func (h *Handler) setReady(ready bool) {
+	h.notReady.Store(!ready)
+}
+
+// serveProbe serves the health, readiness and version endpoints.
+func (h *Handler) serveProbe(wr http.ResponseWriter, route string) {
+	wr.Header().Set("Cache-Control", "no-store")
+
+	switch route {
+	case routeHealth:
+		writeProbe(wr, http.StatusOK, "OK\n")
+
+	case routeReady:
+		if h.notReady.Load() {
+			writeProbe(wr, http.StatusServiceUnavailable, "Shutting down\n")
+			return
+		}
+		writeProbe(wr, http.StatusOK, "Ready\n")
+
+	case routeVersion:
+		uptime := time.Since(h.started)
+		data, err := json.Marshal(versionResponse{
+			versionInfo:   readVersionInfo(),
+			Uptime:        uptime.Round(time.Second).String(),
+			UptimeSeconds: uptime.Seconds(),
+		})
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		wr.Header().Set("Content-Type", "application/json")
+		wr.Write(append(data, '\n')) // nolint:errcheck
+	}
+}
+
+func writeProbe(wr http.ResponseWriter, status int, body string) {
+	wr.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	wr.WriteHeader(status)
+	io.WriteString(wr, body) // nolint:errcheck
+}
@@ -0,0 +1,80 @@
+package echo
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbeEndpoints(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, body := get("/.health"); status != http.StatusOK || body != "OK\n" {
+		t.Errorf("Unexpected health response %d %q", status, body)
+	}
+	if status, body := get("/.ready"); status != http.StatusOK || body != "Ready\n" {
+		t.Errorf("Unexpected ready response %d %q", status, body)
+	}
+
+	handler.setReady(false)
+	if status, _ := get("/.ready"); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once not ready, got %d", status)
+	}
+	if status, _ := get("/.health"); status != http.StatusOK {
+		t.Errorf("Expected health to be unaffected by readiness, got %d", status)
+	}
+
+	status, body := get("/.version")
+	if status != http.StatusOK {
+		t.Fatalf("Unexpected version status %d", status)
+	}
+
+	var version versionResponse
+	if err := json.Unmarshal([]byte(body), &version); err != nil {
+		t.Fatalf("Failed to decode version: %v", err)
+	}
+	if version.Version != "dev" || version.GoVersion == "" || version.Uptime == "" {
+		t.Errorf("Unexpected version response %s", body)
+	}
+}
+
+func TestProbesExcludedFromAccessLog(t *testing.T) {
+	for _, probes := range []bool{false, true} {
+		var buf syncBuffer
+		settings := accessLogSettings{Format: accessLogCommon, Probes: probes}
+		server := httptest.NewServer(newAccessLogHandler(newHandler(testConfig(t)), &buf, settings))
+
+		for _, path := range []string{"/.health", "/"} {
+			resp, err := http.Get(server.URL + path)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+		}
+		server.Close()
+
+		expect := 1
+		if probes {
+			expect = 2
+		}
+		if lines := strings.Count(buf.String(), "\n"); lines != expect {
+			t.Errorf("Expected %d access log lines with probes %t, got %q", expect, probes, buf.String())
+		}
+	}
+}
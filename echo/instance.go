@@ -0,0 +1,75 @@
+package echo
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// servedByHeader is the response header that identifies the instance that
+// served a request.
+const servedByHeader = "X-Served-By"
+
+// defaultInstanceMetadata are the environment variables included in the
+// instance identity by default, which Fly.io sets on every machine.
+var defaultInstanceMetadata = []string{"FLY_REGION", "FLY_ALLOC_ID"}
+
+// instanceIdentity identifies the server instance, so that clients can check
+// how their requests are balanced across instances. It is resolved once at
+// startup.
+type instanceIdentity struct {
+	// ID is INSTANCE_ID, or the hostname if that is not set.
+	ID string `json:"id"`
+
+	// Metadata holds the INSTANCE_METADATA environment variables that are
+	// set, such as the region, keyed by variable name.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// names lists the keys of Metadata in the configured order.
+	names []string
+}
+
+// resolveInstance returns the identity of this instance, reading the metadata
+// variables from env.
+func resolveInstance(id string, metadata []string, env map[string]string) instanceIdentity {
+	instance := instanceIdentity{ID: id}
+
+	if instance.ID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "unknown"
+		}
+		instance.ID = hostname
+	}
+
+	for _, name := range metadata {
+		value, ok := env[name]
+		if !ok {
+			continue
+		}
+		if instance.Metadata == nil {
+			instance.Metadata = map[string]string{}
+		}
+		if _, dup := instance.Metadata[name]; !dup {
+			instance.names = append(instance.names, name)
+		}
+		instance.Metadata[name] = value
+	}
+
+	return instance
+}
+
+// String formats the identity for text responses and the X-Served-By header,
+// e.g. "6e82d4f1 (FLY_REGION=lhr, FLY_ALLOC_ID=6e82d4f1-...)".
+func (i *instanceIdentity) String() string {
+	if len(i.names) == 0 {
+		return i.ID
+	}
+
+	pairs := make([]string, len(i.names))
+	for n, name := range i.names {
+		pairs[n] = fmt.Sprintf("%s=%s", name, i.Metadata[name])
+	}
+
+	return fmt.Sprintf("%s (%s)", i.ID, strings.Join(pairs, ", "))
+}
@@ -0,0 +1,103 @@
+package echo
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveInstance(t *testing.T) {
+	env := map[string]string{"FLY_REGION": "lhr", "ZONE": "b"}
+
+	instance := resolveInstance("web-1", []string{"ZONE", "FLY_ALLOC_ID", "FLY_REGION"}, env)
+	if got := instance.String(); got != "web-1 (ZONE=b, FLY_REGION=lhr)" {
+		t.Errorf("Unexpected identity %q", got)
+	}
+
+	hostname, _ := os.Hostname()
+	instance = resolveInstance("", defaultInstanceMetadata, nil)
+	if instance.ID != hostname || instance.Metadata != nil || instance.String() != hostname {
+		t.Errorf("Expected the hostname without metadata, got %+v", instance)
+	}
+}
+
+func TestServedBy(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "INSTANCE_ID=web-1", "FLY_REGION=lhr")))
+	defer server.Close()
+
+	const identity = "web-1 (FLY_REGION=lhr)"
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if v := resp.Header.Get("X-Served-By"); v != identity {
+		t.Errorf("Expected X-Served-By %q, got %q", identity, v)
+	}
+	if !strings.HasPrefix(string(body), "Request served by "+identity+"\n") {
+		t.Errorf("Expected the text echo to start with the identity, got %q", body)
+	}
+
+	resp, err = http.Get(server.URL + "/?format=json")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var echo echoedRequest
+	err = json.NewDecoder(resp.Body).Decode(&echo)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if echo.ServedBy != "web-1" || echo.Instance["FLY_REGION"] != "lhr" {
+		t.Errorf("Unexpected identity %q %v", echo.ServedBy, echo.Instance)
+	}
+
+	resp, err = http.Get(server.URL + "/.sse")
+	if err != nil {
+		t.Fatalf("Failed to connect to SSE: %v", err)
+	}
+	reader := bufio.NewReader(resp.Body)
+	var event []string
+	for len(event) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		if strings.HasPrefix(line, "event: ") || strings.HasPrefix(line, "data: ") {
+			event = append(event, strings.TrimSpace(line))
+		}
+	}
+	resp.Body.Close()
+	if event[0] != "event: server" || event[1] != "data: "+identity {
+		t.Errorf("Expected a server event with the identity, got %v", event)
+	}
+
+	ws, greeting := dialWebSocket(t, server, "/")
+	defer ws.Close()
+	if !strings.HasPrefix(greeting, "Request served by "+identity) {
+		t.Errorf("Expected the greeting to name the instance, got %q", greeting)
+	}
+}
+
+func TestServedByDisabled(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "SEND_SERVER_HOSTNAME=false")))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if v := resp.Header.Get("X-Served-By"); v != "" {
+		t.Errorf("Expected no X-Served-By header, got %q", v)
+	}
+}
@@ -0,0 +1,248 @@
+package echo
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// connectionRetryAfter is the Retry-After sent when a connection limit is
+	// reached, as there is no way to know when a connection will close.
+	connectionRetryAfter = 5 * time.Second
+
+	// limiterSweepInterval is how often the per-IP rate limit state of
+	// clients that have gone quiet is discarded.
+	limiterSweepInterval = time.Minute
+)
+
+// limitSettings caps the number of long-lived (WebSocket and SSE)
+// connections and the request rate, both server-wide and per client IP.
+// Zero values are unlimited.
+type limitSettings struct {
+	MaxConnections      int `json:"max_connections,omitempty"`
+	MaxConnectionsPerIP int `json:"max_connections_per_ip,omitempty"`
+
+	// RateLimit and RateLimitPerIP are in requests per second. Bursts of up
+	// to one second's worth of requests are allowed.
+	RateLimit      float64 `json:"rate_limit,omitempty"`
+	RateLimitPerIP float64 `json:"rate_limit_per_ip,omitempty"`
+}
+
+// limitError describes a request rejected by a limit.
+type limitError struct {
+	// limit names the limit that was reached, for logs and metrics.
+	limit      string
+	status     int
+	retryAfter time.Duration
+	message    string
+}
+
+func (e *limitError) Error() string {
+	return e.message
+}
+
+// retryAfterSeconds returns the Retry-After header value, rounded up to a
+// whole number of seconds.
+func (e *limitError) retryAfterSeconds() string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(e.retryAfter.Seconds()))))
+}
+
+// limiter enforces limitSettings.
+type limiter struct {
+	settings limitSettings
+	now      func() time.Time
+
+	mu               sync.Mutex
+	connections      int
+	connectionsPerIP map[string]int
+	rate             *tokenBucket
+	ratePerIP        map[string]*tokenBucket
+	lastSweep        time.Time
+}
+
+func newLimiter(settings limitSettings) *limiter {
+	l := &limiter{
+		settings:         settings,
+		now:              time.Now,
+		connectionsPerIP: map[string]int{},
+		ratePerIP:        map[string]*tokenBucket{},
+	}
+	l.lastSweep = l.now()
+
+	if settings.RateLimit > 0 {
+		l.rate = newTokenBucket(settings.RateLimit, l.lastSweep)
+	}
+
+	return l
+}
+
+// allowRequest takes a token from the server-wide and per-IP rate limits, and
+// returns an error if either is exhausted.
+func (l *limiter) allowRequest(ip string) *limitError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	if l.settings.RateLimitPerIP > 0 {
+		if now.Sub(l.lastSweep) > limiterSweepInterval {
+			l.sweep(now)
+		}
+
+		bucket, ok := l.ratePerIP[ip]
+		if !ok {
+			bucket = newTokenBucket(l.settings.RateLimitPerIP, now)
+			l.ratePerIP[ip] = bucket
+		}
+
+		if wait := bucket.take(now); wait > 0 {
+			return &limitError{
+				limit:      "rate_per_ip",
+				status:     http.StatusTooManyRequests,
+				retryAfter: wait,
+				message:    fmt.Sprintf("Too many requests from %s", ip),
+			}
+		}
+	}
+
+	if l.rate != nil {
+		if wait := l.rate.take(now); wait > 0 {
+			return &limitError{
+				limit:      "rate",
+				status:     http.StatusServiceUnavailable,
+				retryAfter: wait,
+				message:    "Server is receiving too many requests",
+			}
+		}
+	}
+
+	return nil
+}
+
+// sweep discards the buckets of clients whose buckets have refilled, as they
+// are equivalent to new ones.
+func (l *limiter) sweep(now time.Time) {
+	for ip, bucket := range l.ratePerIP {
+		if bucket.full(now) {
+			delete(l.ratePerIP, ip)
+		}
+	}
+	l.lastSweep = now
+}
+
+// acquireConnection reserves a long-lived connection for ip, and returns a
+// function that releases it, or an error if a connection limit is reached.
+func (l *limiter) acquireConnection(ip string) (func(), *limitError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limit := l.settings.MaxConnectionsPerIP; limit > 0 && l.connectionsPerIP[ip] >= limit {
+		return nil, &limitError{
+			limit:      "connections_per_ip",
+			status:     http.StatusTooManyRequests,
+			retryAfter: connectionRetryAfter,
+			message:    fmt.Sprintf("Too many connections from %s (limit %d)", ip, limit),
+		}
+	}
+
+	if limit := l.settings.MaxConnections; limit > 0 && l.connections >= limit {
+		return nil, &limitError{
+			limit:      "connections",
+			status:     http.StatusServiceUnavailable,
+			retryAfter: connectionRetryAfter,
+			message:    fmt.Sprintf("Server is at its connection limit (%d)", limit),
+		}
+	}
+
+	l.connections++
+	l.connectionsPerIP[ip]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			l.connections--
+			if l.connectionsPerIP[ip]--; l.connectionsPerIP[ip] == 0 {
+				delete(l.connectionsPerIP, ip)
+			}
+		})
+	}, nil
+}
+
+// tokenBucket allows rate events per second, in bursts of up to one second's
+// worth. It is not safe for concurrent use.
+type tokenBucket struct {
+	rate    float64
+	burst   float64
+	tokens  float64
+	updated time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	burst := math.Max(1, rate)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, updated: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.Before(b.updated) {
+		return
+	}
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.updated).Seconds()*b.rate)
+	b.updated = now
+}
+
+// take takes a token, and returns 0 if one was available or how long until
+// one will be.
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// rejectRequest responds to a request rejected by a limit with its status and
+// a Retry-After header. WebSocket requests from allowed origins are upgraded
+// and then closed with 1013 Try Again Later, as browsers do not expose the
+// status of a failed upgrade. Those from other origins are not upgraded, as
+// rate limits are applied before the origin is checked.
+func (h *Handler) rejectRequest(wr http.ResponseWriter, req *http.Request, log *slog.Logger, transport string, limitErr *limitError) {
+	h.metrics.rejections.add(1, limitErr.limit)
+	log.Warn("limit reached", "limit", limitErr.limit, "retry_after", limitErr.retryAfter)
+
+	retryAfter := limitErr.retryAfterSeconds()
+
+	if transport != "websocket" || checkOrigin(req, h.config.AllowedOrigins) != nil {
+		wr.Header().Set("Retry-After", retryAfter)
+		http.Error(wr, limitErr.message, limitErr.status)
+		return
+	}
+
+	connection, err := upgrader.Upgrade(wr, req, http.Header{"Retry-After": {retryAfter}})
+	if err != nil {
+		log.Warn("websocket upgrade failed", "error", err)
+		return
+	}
+	defer connection.Close()
+
+	_ = connection.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseTryAgainLater, limitErr.message),
+		time.Now().Add(controlWriteWait))
+}
@@ -0,0 +1,193 @@
+package echo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLimiterRate(t *testing.T) {
+	now := time.Now()
+	l := newLimiter(limitSettings{RateLimit: 3, RateLimitPerIP: 2})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if err := l.allowRequest("10.0.0.1"); err != nil {
+			t.Fatalf("Request %d rejected: %v", i, err)
+		}
+	}
+
+	err := l.allowRequest("10.0.0.1")
+	if err == nil || err.status != http.StatusTooManyRequests || err.limit != "rate_per_ip" {
+		t.Fatalf("Expected the per-IP rate limit, got %+v", err)
+	}
+	if err.retryAfter != 500*time.Millisecond || err.retryAfterSeconds() != "1" {
+		t.Errorf("Unexpected retry after %s (%s)", err.retryAfter, err.retryAfterSeconds())
+	}
+
+	// Another client has its own allowance, but the server-wide limit is
+	// shared.
+	if err := l.allowRequest("10.0.0.2"); err != nil {
+		t.Fatalf("Request from another IP rejected: %v", err)
+	}
+	err = l.allowRequest("10.0.0.2")
+	if err == nil || err.status != http.StatusServiceUnavailable || err.limit != "rate" {
+		t.Fatalf("Expected the server-wide rate limit, got %+v", err)
+	}
+
+	now = now.Add(time.Second)
+	if err := l.allowRequest("10.0.0.1"); err != nil {
+		t.Errorf("Request rejected after the bucket refilled: %v", err)
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	now := time.Now()
+	l := newLimiter(limitSettings{RateLimitPerIP: 1})
+	l.now = func() time.Time { return now }
+
+	l.allowRequest("10.0.0.1")
+	now = now.Add(2 * limiterSweepInterval)
+	l.allowRequest("10.0.0.2")
+
+	if _, ok := l.ratePerIP["10.0.0.1"]; ok {
+		t.Error("Expected the idle client to be swept")
+	}
+	if _, ok := l.ratePerIP["10.0.0.2"]; !ok {
+		t.Error("Expected the active client to be kept")
+	}
+}
+
+func TestLimiterConnections(t *testing.T) {
+	l := newLimiter(limitSettings{MaxConnections: 2, MaxConnectionsPerIP: 1})
+
+	release, err := l.acquireConnection("10.0.0.1")
+	if err != nil {
+		t.Fatalf("First connection rejected: %v", err)
+	}
+
+	if _, err := l.acquireConnection("10.0.0.1"); err == nil || err.limit != "connections_per_ip" {
+		t.Errorf("Expected the per-IP connection limit, got %v", err)
+	}
+
+	if _, err := l.acquireConnection("10.0.0.2"); err != nil {
+		t.Fatalf("Connection from another IP rejected: %v", err)
+	}
+
+	if _, err := l.acquireConnection("10.0.0.3"); err == nil || err.status != http.StatusServiceUnavailable {
+		t.Errorf("Expected the server-wide connection limit, got %v", err)
+	}
+
+	release()
+	release()
+	if _, err := l.acquireConnection("10.0.0.1"); err != nil {
+		t.Errorf("Expected the released connection to be available, got %v", err)
+	}
+	if l.connections != 2 {
+		t.Errorf("Expected 2 connections, got %d", l.connections)
+	}
+}
+
+func TestRateLimitResponse(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "RATE_LIMIT_PER_IP=1")))
+	defer server.Close()
+
+	var resp *http.Response
+	for i := 0; i < 2; i++ {
+		var err error
+		resp, err = http.Get(server.URL + "/")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected 429, got %d", resp.StatusCode)
+	}
+	if v := resp.Header.Get("Retry-After"); v != "1" {
+		t.Errorf("Expected Retry-After 1, got %q", v)
+	}
+
+	// Probes are not rate limited.
+	resp, err := http.Get(server.URL + "/.health")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the health endpoint to be exempt, got %d", resp.StatusCode)
+	}
+}
+
+func TestWebSocketConnectionLimit(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "MAX_CONNECTIONS_PER_IP=1")))
+	defer server.Close()
+
+	_, greeting := dialWebSocket(t, server, "/")
+	if !strings.Contains(greeting, "Connection limit per IP: 1") {
+		t.Errorf("Expected greeting to report the limit, got %q", greeting)
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/"
+	ws, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer ws.Close()
+
+	if v := resp.Header.Get("Retry-After"); v != "5" {
+		t.Errorf("Expected Retry-After 5, got %q", v)
+	}
+
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+		t.Errorf("Expected close 1013, got %v", err)
+	}
+}
+
+func TestWebSocketRateLimitOriginRejected(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "RATE_LIMIT_PER_IP=1", "ALLOWED_ORIGINS=https://allowed.test")))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/"
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"https://denied.test"}})
+	if err == nil {
+		t.Fatalf("Expected the handshake to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "1" {
+		t.Fatalf("Expected 429 with Retry-After 1 without an upgrade, got %v", resp)
+	}
+}
+
+func TestSSEConnectionLimit(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "MAX_CONNECTIONS=1")))
+	defer server.Close()
+
+	first, err := http.Get(server.URL + "/.sse")
+	if err != nil {
+		t.Fatalf("Failed to connect to SSE: %v", err)
+	}
+	defer first.Body.Close()
+
+	resp, err := http.Get(server.URL + "/.sse")
+	if err != nil {
+		t.Fatalf("Failed to connect to SSE: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Expected 503 with Retry-After, got %d", resp.StatusCode)
+	}
+}
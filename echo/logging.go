@@ -0,0 +1,103 @@
+package echo
+
+import (
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// newLogger returns a logger that writes records at or above the configured
+// level to w, in the configured format.
+func newLogger(w io.Writer, c *config) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: c.LogLevel}
+
+	if c.LogFormat == logFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// discardLogger returns a logger that drops every record.
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// LogValue logs the effective value of every option as a group.
+func (c *config) LogValue() slog.Value {
+	var attrs []slog.Attr
+
+	for _, opt := range configOptions {
+		if opt.get != nil {
+			attrs = append(attrs, slog.String(opt.name, opt.get(c)))
+		}
+	}
+
+	for header := range c.SendHeaders {
+		attrs = append(attrs, slog.String(sendHeaderPrefix+header, c.SendHeaders.Get(header)))
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
+// bodyAttrs describes a request body for a debug log record. Bodies that are
+// not valid UTF-8 are base64 encoded.
+func bodyAttrs(body []byte) []any {
+	if utf8.Valid(body) {
+		return []any{"bytes", len(body), "body", string(body)}
+	}
+
+	return []any{"bytes", len(body), "body", base64.StdEncoding.EncodeToString(body), "base64", true}
+}
+
+// messageTypeName returns the name of a WebSocket message type for logging.
+func messageTypeName(messageType int) string {
+	if messageType == websocket.TextMessage {
+		return "text"
+	}
+	return "binary"
+}
+
+// responseRecorder records the status code and size of a response so that
+// they can be logged once it is complete.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush flushes the underlying response, if it supports flushing.
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
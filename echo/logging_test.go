@@ -0,0 +1,139 @@
+package echo
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// logRecords decodes the JSON log records written to buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var record map[string]any
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("Failed to decode log record: %v", err)
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestRequestLogRecord(t *testing.T) {
+	var buf bytes.Buffer
+
+	cfg := testConfig(t, "LOG_FORMAT=json")
+	handler := newHandler(cfg)
+	handler.logger = newLogger(&buf, cfg)
+
+	req := httptest.NewRequest("GET", "/test?x=1", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	records := logRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 log record, got %d", len(records))
+	}
+
+	record := records[0]
+	expect := map[string]any{
+		"level":       "INFO",
+		"msg":         "request",
+		"conn_id":     float64(1),
+		"remote_addr": req.RemoteAddr,
+		"transport":   "http",
+		"method":      "GET",
+		"path":        "/test?x=1",
+		"status":      float64(200),
+		"bytes":       float64(rec.Body.Len()),
+	}
+	for key, value := range expect {
+		if record[key] != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, record[key])
+		}
+	}
+	if _, ok := record["duration"]; !ok {
+		t.Error("Expected record to contain a duration")
+	}
+}
+
+func TestRequestBodyLogRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		expect string
+	}{
+		{"Text", "hello", "hello"},
+		{"Binary", "\xff\xfe", "//4="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			cfg := testConfig(t, "LOG_FORMAT=json", "LOG_HTTP_BODY=true")
+			handler := newHandler(cfg)
+			handler.logger = newLogger(&buf, cfg)
+
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			records := logRecords(t, &buf)
+			if len(records) != 2 {
+				t.Fatalf("Expected 2 log records, got %d", len(records))
+			}
+
+			record := records[0]
+			if record["level"] != "DEBUG" || record["msg"] != "request body" {
+				t.Fatalf("Unexpected log record %v", record)
+			}
+			if record["body"] != tt.expect {
+				t.Errorf("Expected body %q, got %v", tt.expect, record["body"])
+			}
+			if record["bytes"] != float64(len(tt.body)) {
+				t.Errorf("Expected %d bytes, got %v", len(tt.body), record["bytes"])
+			}
+		})
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    []string
+		expect slog.Level
+	}{
+		{"Default", nil, slog.LevelInfo},
+		{"Warn", []string{"LOG_LEVEL=warn"}, slog.LevelWarn},
+		{"HTTPBody", []string{"LOG_HTTP_BODY=true"}, slog.LevelDebug},
+		{"HTTPBodyWithLevel", []string{"LOG_HTTP_BODY=true", "LOG_LEVEL=error"}, slog.LevelError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cfg := testConfig(t, tt.env...); cfg.LogLevel != tt.expect {
+				t.Errorf("Expected level %s, got %s", tt.expect, cfg.LogLevel)
+			}
+		})
+	}
+}
+
+func TestLogLevelFiltering(t *testing.T) {
+	var buf bytes.Buffer
+
+	cfg := testConfig(t, "LOG_LEVEL=warn")
+	handler := newHandler(cfg)
+	handler.logger = newLogger(&buf, cfg)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if buf.Len() != 0 {
+		t.Errorf("Expected no log output, got %q", buf.String())
+	}
+}
@@ -0,0 +1,281 @@
+package echo
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsContentType is the content type of the Prometheus text exposition
+// format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	// requestDurationBuckets are the upper bounds, in seconds, of the HTTP
+	// request latency histogram.
+	requestDurationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+	// connectionDurationBuckets are the upper bounds, in seconds, of the
+	// WebSocket and SSE connection lifetime histogram.
+	connectionDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}
+)
+
+// metrics holds the server metrics, which are served at /.metrics in the
+// Prometheus text format.
+type metrics struct {
+	requests           *metricVec
+	requestDuration    *histogram
+	connections        *metricVec
+	connectionDuration *histogram
+	timeouts           *metricVec
+	messages           *metricVec
+	messageBytes       *metricVec
+	rejections         *metricVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		requests: newMetricVec("counter", "echo_http_requests_total",
+			"HTTP requests served, by method and status.", "method", "status"),
+		requestDuration: newHistogram("echo_http_request_duration_seconds",
+			"Latency of HTTP requests, excluding WebSocket and SSE connections.", requestDurationBuckets),
+		connections: newMetricVec("gauge", "echo_connections_active",
+			"WebSocket and SSE connections currently open.", "transport"),
+		connectionDuration: newHistogram("echo_connection_duration_seconds",
+			"Lifetime of closed WebSocket and SSE connections.", connectionDurationBuckets, "transport"),
+		timeouts: newMetricVec("counter", "echo_connection_timeouts_total",
+			"WebSocket and SSE connections, and delayed or streamed HTTP responses, closed by the connection timeout.", "transport"),
+		messages: newMetricVec("counter", "echo_websocket_messages_total",
+			"WebSocket messages, by direction and type.", "direction", "type"),
+		messageBytes: newMetricVec("counter", "echo_websocket_message_bytes_total",
+			"WebSocket message payload bytes, by direction and type.", "direction", "type"),
+		rejections: newMetricVec("counter", "echo_limit_rejections_total",
+			"Requests rejected by a connection or rate limit, by limit.", "limit"),
+	}
+
+	// The connection metrics are reported before the first connection, so
+	// that they can be graphed from zero.
+	for _, transport := range []string{"websocket", "sse"} {
+		m.connections.add(0, transport)
+		m.timeouts.add(0, transport)
+	}
+
+	return m
+}
+
+// requestMethods are the methods reported in the request metrics. Other
+// methods are reported as "other", so that clients cannot create an unbounded
+// number of series.
+var requestMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// observeRequest records a served HTTP request. The latency is only recorded
+// for requests that are not long-lived connections.
+func (m *metrics) observeRequest(method string, status int, transport string, seconds float64) {
+	if !requestMethods[method] {
+		method = "other"
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	m.requests.add(1, method, strconv.Itoa(status))
+	if transport == "http" {
+		m.requestDuration.observe(seconds)
+	}
+}
+
+// connectionOpened records a WebSocket or SSE connection being opened.
+func (m *metrics) connectionOpened(transport string) {
+	m.connections.add(1, transport)
+}
+
+// connectionClosed records a WebSocket or SSE connection being closed after
+// lifetime.
+func (m *metrics) connectionClosed(transport string, lifetime time.Duration) {
+	m.connections.add(-1, transport)
+	m.connectionDuration.observe(lifetime.Seconds(), transport)
+}
+
+// observeMessage records a WebSocket message sent ("out") or received ("in").
+func (m *metrics) observeMessage(direction string, messageType int, bytes int64) {
+	m.messages.add(1, direction, messageTypeName(messageType))
+	m.messageBytes.add(float64(bytes), direction, messageTypeName(messageType))
+}
+
+func (m *metrics) ServeHTTP(wr http.ResponseWriter, _ *http.Request) {
+	wr.Header().Set("Content-Type", metricsContentType)
+	m.write(wr)
+}
+
+// write writes every metric to w in the Prometheus text format.
+func (m *metrics) write(w io.Writer) {
+	m.requests.write(w)
+	m.requestDuration.write(w)
+	m.connections.write(w)
+	m.connectionDuration.write(w)
+	m.timeouts.write(w)
+	m.messages.write(w)
+	m.messageBytes.write(w)
+	m.rejections.write(w)
+}
+
+// metricVec is a counter or gauge with a value for each combination of label
+// values.
+type metricVec struct {
+	kind   string
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newMetricVec(kind, name, help string, labels ...string) *metricVec {
+	return &metricVec{kind: kind, name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (v *metricVec) add(delta float64, labelValues ...string) {
+	key := seriesKey(labelValues)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[key] += delta
+}
+
+func (v *metricVec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	writeMetricHeader(w, v.name, v.help, v.kind)
+	for _, key := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, key), formatValue(v.values[key]))
+	}
+}
+
+// histogram counts observations in buckets, for each combination of label
+// values.
+type histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	// counts holds the number of observations in each bucket, followed by
+	// those greater than every bucket.
+	counts []uint64
+	sum    float64
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *histogram {
+	return &histogram{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+}
+
+func (h *histogram) observe(value float64, labelValues ...string) {
+	key := seriesKey(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+
+	s.counts[sort.SearchFloat64s(h.buckets, value)]++
+	s.sum += value
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	bucketLabels := append(append([]string{}, h.labels...), "le")
+
+	writeMetricHeader(w, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var count uint64
+		for i, n := range s.counts {
+			count += n
+
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatValue(h.buckets[i])
+			}
+			bucketKey := le
+			if len(h.labels) > 0 {
+				bucketKey = key + "\x00" + le
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, bucketKey), count)
+		}
+
+		labels := formatLabels(h.labels, key)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, count)
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// seriesKey joins label values into a map key.
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\x00")
+}
+
+// labelValueEscaper escapes label values as required by the text format.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats the label values in key as a label set, e.g.
+// {method="GET",status="200"}.
+func formatLabels(labels []string, key string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	values := strings.Split(key, "\x00")
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, label, labelValueEscaper.Replace(values[i]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
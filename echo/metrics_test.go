@@ -0,0 +1,116 @@
+package echo
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	m := newMetrics()
+	m.observeRequest("GET", 200, "http", 0.003)
+	m.observeRequest("GET", 0, "http", 20)
+	m.observeRequest("BREW", 418, "http", 0.5)
+	m.connectionOpened("websocket")
+	m.connectionClosed("websocket", 2*time.Second)
+
+	var buf bytes.Buffer
+	m.write(&buf)
+	out := buf.String()
+
+	for _, expect := range []string{
+		"# TYPE echo_http_requests_total counter\n",
+		`echo_http_requests_total{method="GET",status="200"} 2` + "\n",
+		`echo_http_requests_total{method="other",status="418"} 1` + "\n",
+		"# TYPE echo_http_request_duration_seconds histogram\n",
+		`echo_http_request_duration_seconds_bucket{le="0.001"} 0` + "\n",
+		`echo_http_request_duration_seconds_bucket{le="0.005"} 1` + "\n",
+		`echo_http_request_duration_seconds_bucket{le="0.5"} 2` + "\n",
+		`echo_http_request_duration_seconds_bucket{le="10"} 2` + "\n",
+		`echo_http_request_duration_seconds_bucket{le="+Inf"} 3` + "\n",
+		"echo_http_request_duration_seconds_sum 20.503\n",
+		"echo_http_request_duration_seconds_count 3\n",
+		`echo_connections_active{transport="websocket"} 0` + "\n",
+		`echo_connections_active{transport="sse"} 0` + "\n",
+		`echo_connection_duration_seconds_bucket{transport="websocket",le="1"} 0` + "\n",
+		`echo_connection_duration_seconds_bucket{transport="websocket",le="5"} 1` + "\n",
+		`echo_connection_duration_seconds_count{transport="websocket"} 1` + "\n",
+		`echo_connection_timeouts_total{transport="sse"} 0` + "\n",
+	} {
+		if !strings.Contains(out, expect) {
+			t.Errorf("Metrics do not contain %q:\n%s", expect, out)
+		}
+	}
+}
+
+// scrapeMetrics returns the metrics served by server.
+func scrapeMetrics(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+
+	resp, err := http.Get(server.URL + "/.metrics")
+	if err != nil {
+		t.Fatalf("Failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != metricsContentType {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "SEND_SERVER_HOSTNAME=false")))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	ws, _ := dialWebSocket(t, server, "/")
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+
+	if out := scrapeMetrics(t, server); !strings.Contains(out, `echo_connections_active{transport="websocket"} 1`) {
+		t.Errorf("Expected an active WebSocket connection:\n%s", out)
+	}
+
+	ws.Close()
+
+	// The connection is recorded as closed once the server notices.
+	var out string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		out = scrapeMetrics(t, server)
+		if strings.Contains(out, `echo_connections_active{transport="websocket"} 0`) {
+			break
+		}
+	}
+
+	for _, expect := range []string{
+		`echo_http_requests_total{method="POST",status="200"} 1`,
+		`echo_http_requests_total{method="GET",status="101"} 1`,
+		`echo_connections_active{transport="websocket"} 0`,
+		`echo_connection_duration_seconds_count{transport="websocket"} 1`,
+		`echo_websocket_messages_total{direction="in",type="text"} 1`,
+		`echo_websocket_messages_total{direction="out",type="text"} 2`,
+		`echo_websocket_message_bytes_total{direction="in",type="text"} 5`,
+	} {
+		if !strings.Contains(out, expect) {
+			t.Errorf("Metrics do not contain %q:\n%s", expect, out)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package echo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ably/echo.websocket.org/echo"
+	"github.com/gorilla/websocket"
+)
+
+func TestNew(t *testing.T) {
+	handler, err := echo.New(echo.Options{InstanceID: "test-1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Served-By") != "test-1" {
+		t.Errorf("Expected the request to be echoed, got %d %v", rec.Code, rec.Header())
+	}
+
+	if _, err := echo.New(echo.Options{Settings: map[string]string{"COLOUR": "blue"}}); err == nil || !strings.Contains(err.Error(), `unknown option "COLOUR"`) {
+		t.Errorf("Expected an error for an unknown option, got %v", err)
+	}
+}
+
+func TestHandlerShutdown(t *testing.T) {
+	handler := echo.MustNew(echo.Options{})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer ws.Close()
+
+	// Read until the close frame is received, which replies to it.
+	go func() {
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := handler.Shutdown(ctx); err != nil {
+		t.Errorf("Expected the WebSocket to close, got %v", err)
+	}
+}
+
+func TestMustNewInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   echo.Options
+		expect string
+	}{
+		{"InvalidProxies", echo.Options{TrustedProxies: []string{"somewhere"}}, `invalid TRUSTED_PROXIES "somewhere" (from options)`},
+		{"NegativeTimeout", echo.Options{ConnectionTimeout: -time.Second}, "must be a positive number of minutes"},
+		{"UnknownSetting", echo.Options{Settings: map[string]string{"COLOUR": "blue"}}, `unknown option "COLOUR"`},
+		{"ServerSetting", echo.Options{Settings: map[string]string{"PORT": "9000"}}, `option "PORT" is only used by the echo-server command`},
+		{"InvalidSetting", echo.Options{Settings: map[string]string{"MAX_CONNECTIONS": "many"}}, `invalid MAX_CONNECTIONS "many" (from settings)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if msg, _ := r.(string); !strings.Contains(msg, tt.expect) {
+					t.Errorf("Expected a panic containing %q, got %v", tt.expect, r)
+				}
+			}()
+
+			echo.MustNew(tt.opts)
+		})
+	}
+}
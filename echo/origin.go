@@ -0,0 +1,113 @@
+package echo
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// sameOrigin is the ALLOWED_ORIGINS entry that allows requests whose Origin
+// matches the Host they were sent to.
+const sameOrigin = "same-origin"
+
+// originPolicy decides which Origin headers are accepted on WebSocket
+// upgrades.
+type originPolicy struct {
+	// AllowAll accepts any origin.
+	AllowAll bool
+
+	// SameOrigin accepts origins whose host matches the request Host.
+	SameOrigin bool
+
+	// Patterns are the allowed origins. A pattern with a scheme, such as
+	// "https://example.com", must match the origin exactly; one without
+	// matches the host over any scheme. A leading "*." matches any subdomain.
+	Patterns []string
+}
+
+// parseOriginPolicy parses a comma-separated list of allowed origins. Any
+// origin is allowed if the list is empty or contains "*".
+func parseOriginPolicy(v string) originPolicy {
+	if strings.TrimSpace(v) == "" {
+		return originPolicy{AllowAll: true}
+	}
+
+	var policy originPolicy
+	for _, p := range strings.Split(v, ",") {
+		switch p = strings.ToLower(strings.TrimSpace(p)); p {
+		case "":
+		case "*":
+			policy.AllowAll = true
+		case sameOrigin:
+			policy.SameOrigin = true
+		default:
+			policy.Patterns = append(policy.Patterns, strings.TrimSuffix(p, "/"))
+		}
+	}
+
+	return policy
+}
+
+// String returns the policy in the form accepted by parseOriginPolicy.
+func (p originPolicy) String() string {
+	var origins []string
+	if p.AllowAll {
+		origins = append(origins, "*")
+	}
+	if p.SameOrigin {
+		origins = append(origins, sameOrigin)
+	}
+	return strings.Join(append(origins, p.Patterns...), ",")
+}
+
+// checkOrigin returns an error explaining why req is not allowed by policy.
+// Requests without an Origin header come from non-browser clients and are
+// always allowed.
+func checkOrigin(req *http.Request, policy originPolicy) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" || policy.AllowAll {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid origin %q", origin)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+
+	if policy.SameOrigin && host == strings.ToLower(req.Host) {
+		return nil
+	}
+
+	for _, pattern := range policy.Patterns {
+		if matchOrigin(pattern, scheme, host) {
+			return nil
+		}
+	}
+
+	if policy.SameOrigin && len(policy.Patterns) == 0 {
+		return fmt.Errorf("origin %q does not match host %q", origin, req.Host)
+	}
+
+	return fmt.Errorf("origin %q is not in the list of allowed origins", origin)
+}
+
+// matchOrigin returns true if the origin with the given scheme and host is
+// allowed by pattern.
+func matchOrigin(pattern, scheme, host string) bool {
+	if patternScheme, rest, ok := strings.Cut(pattern, "://"); ok {
+		if patternScheme != scheme {
+			return false
+		}
+		pattern = rest
+	}
+
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+
+	return host == pattern
+}
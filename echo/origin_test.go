@@ -0,0 +1,78 @@
+package echo
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       string
+		origin    string
+		expectErr bool
+	}{
+		{"DefaultAllowsAll", "", "https://anywhere.test", false},
+		{"Wildcard", "https://example.com, *", "https://anywhere.test", false},
+		{"NoOrigin", "https://example.com", "", false},
+		{"Exact", "https://example.com", "https://example.com", false},
+		{"ExactCaseInsensitive", "https://Example.com/", "HTTPS://EXAMPLE.COM", false},
+		{"SchemeMismatch", "https://example.com", "http://example.com", true},
+		{"AnyScheme", "example.com", "http://example.com", false},
+		{"Port", "http://localhost:3000", "http://localhost:3000", false},
+		{"PortMismatch", "http://localhost:3000", "http://localhost:4000", true},
+		{"Subdomain", "*.example.com", "https://app.example.com", false},
+		{"SubdomainWithScheme", "https://*.example.com", "http://app.example.com", true},
+		{"SubdomainExcludesApex", "*.example.com", "https://example.com", true},
+		{"SuffixIsNotSubstring", "*.example.com", "https://app.example.com.evil.test", true},
+		{"SameOrigin", "same-origin", "http://echo.test", false},
+		{"SameOriginMismatch", "same-origin", "http://other.test", true},
+		{"SameOriginOrList", "same-origin, https://example.com", "https://example.com", false},
+		{"Invalid", "https://example.com", "null", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://echo.test/", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+
+			err := checkOrigin(req, parseOriginPolicy(tt.env))
+			if (err != nil) != tt.expectErr {
+				t.Errorf("Expected error=%v, got %v", tt.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestWebSocketOriginRejected(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "ALLOWED_ORIGINS=https://allowed.test")))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/"
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"https://denied.test"}})
+	if err == nil {
+		t.Fatalf("Expected the handshake to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %v", resp)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `origin "https://denied.test" is not in the list of allowed origins`) {
+		t.Errorf("Expected an explanatory body, got %q", body)
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"https://allowed.test"}})
+	if err != nil {
+		t.Fatalf("Expected allowed origin to connect: %v", err)
+	}
+	ws.Close()
+}
@@ -0,0 +1,242 @@
+package echo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// proxyRanges are the named ranges accepted in TRUSTED_PROXIES.
+var proxyRanges = map[string][]string{
+	"loopback": {"127.0.0.0/8", "::1/128"},
+	"private":  {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"},
+}
+
+// trustedProxies are the addresses whose forwarding headers, and PROXY
+// protocol headers, are believed.
+type trustedProxies struct {
+	entries  []string
+	prefixes []netip.Prefix
+}
+
+// parseTrustedProxies parses a comma-separated list of CIDR ranges, IP
+// addresses, and the named ranges "loopback" and "private".
+func parseTrustedProxies(v string) (trustedProxies, error) {
+	var proxies trustedProxies
+
+	for _, entry := range splitList(v) {
+		ranges, ok := proxyRanges[strings.ToLower(entry)]
+		if !ok {
+			ranges = []string{entry}
+		}
+
+		for _, r := range ranges {
+			prefix, err := netip.ParsePrefix(r)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(r)
+				if addrErr != nil {
+					return trustedProxies{}, fmt.Errorf("%q is not an IP address or CIDR range", entry)
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			proxies.prefixes = append(proxies.prefixes, prefix.Masked())
+		}
+		proxies.entries = append(proxies.entries, entry)
+	}
+
+	return proxies, nil
+}
+
+// String returns the list in the form accepted by parseTrustedProxies.
+func (p trustedProxies) String() string {
+	return strings.Join(p.entries, ",")
+}
+
+// contains returns true if addr, an IP address, is a trusted proxy.
+func (p trustedProxies) contains(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientInfo describes the client that sent a request, as derived from the
+// forwarding headers of trusted proxies.
+type clientInfo struct {
+	// Addr is the address of the client.
+	Addr string
+
+	// Peer is the address of the socket peer, which is the nearest proxy if
+	// the request was forwarded.
+	Peer string
+
+	// Proxies are the trusted proxies the request passed through, nearest
+	// first.
+	Proxies []string
+
+	// Scheme is the scheme the client used to reach the first proxy.
+	Scheme string
+}
+
+type clientInfoKey struct{}
+
+// newClientHandler resolves the client of each request before passing it to
+// next, so that every handler in the chain sees the same client.
+func newClientHandler(next http.Handler, proxies trustedProxies) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(wr, withClientInfo(req, proxies))
+	})
+}
+
+// withClientInfo returns req with its client resolved, unless it already is.
+func withClientInfo(req *http.Request, proxies trustedProxies) *http.Request {
+	if _, ok := req.Context().Value(clientInfoKey{}).(*clientInfo); ok {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), clientInfoKey{}, resolveClient(req, proxies)))
+}
+
+// requestClient returns the client of req, resolving it without any trusted
+// proxies if no handler has done so.
+func requestClient(req *http.Request) *clientInfo {
+	if info, ok := req.Context().Value(clientInfoKey{}).(*clientInfo); ok {
+		return info
+	}
+	return resolveClient(req, trustedProxies{})
+}
+
+// clientIP returns the IP address of the client that sent req.
+func clientIP(req *http.Request) string {
+	return requestClient(req).Addr
+}
+
+// requestScheme returns the scheme the client used to reach the server.
+func requestScheme(req *http.Request) string {
+	return requestClient(req).Scheme
+}
+
+// resolveClient derives the client of req. Starting from the socket peer, or
+// the source address given by a PROXY protocol header, each address that is
+// a trusted proxy is replaced by the address it forwarded the request for,
+// until an untrusted address is reached.
+func resolveClient(req *http.Request, proxies trustedProxies) *clientInfo {
+	info := &clientInfo{Peer: req.RemoteAddr, Scheme: "http"}
+	if req.TLS != nil {
+		info.Scheme = "https"
+	}
+
+	addr := hostOnly(req.RemoteAddr)
+
+	// A PROXY protocol header, which is only accepted from trusted proxies,
+	// replaces the address of the connection.
+	if conn, ok := req.Context().Value(proxyConnKey{}).(*proxyConn); ok && conn.proxied() {
+		info.Peer = conn.Conn.RemoteAddr().String()
+		info.Proxies = append(info.Proxies, hostOnly(info.Peer))
+	}
+
+	if !proxies.contains(addr) {
+		info.Addr = addr
+		return info
+	}
+
+	forwardedFor, proto := forwardingHeaders(req.Header)
+	if req.TLS == nil && (proto == "http" || proto == "https") {
+		info.Scheme = proto
+	}
+
+	for i := len(forwardedFor) - 1; i >= 0 && proxies.contains(addr); i-- {
+		info.Proxies = append(info.Proxies, addr)
+		addr = forwardedFor[i]
+	}
+
+	info.Addr = addr
+	return info
+}
+
+// forwardingHeaders returns the addresses a request was forwarded for, from
+// the client to the nearest proxy, and the scheme used by the client. The
+// RFC 7239 Forwarded header takes precedence over X-Forwarded-For and
+// X-Forwarded-Proto, which take precedence over X-Real-IP.
+func forwardingHeaders(h http.Header) ([]string, string) {
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		var addrs []string
+		var proto string
+
+		for _, element := range splitHeaderList(values) {
+			params := parseForwardedElement(element)
+			if v, ok := params["for"]; ok {
+				addrs = append(addrs, hostOnly(v))
+			}
+			if v, ok := params["proto"]; ok && proto == "" {
+				proto = strings.ToLower(v)
+			}
+		}
+
+		return addrs, proto
+	}
+
+	proto := ""
+	if protos := splitHeaderList(h.Values("X-Forwarded-Proto")); len(protos) > 0 {
+		proto = strings.ToLower(protos[0])
+	}
+
+	if values := h.Values("X-Forwarded-For"); len(values) > 0 {
+		var addrs []string
+		for _, v := range splitHeaderList(values) {
+			addrs = append(addrs, hostOnly(v))
+		}
+		return addrs, proto
+	}
+
+	if v := strings.TrimSpace(h.Get("X-Real-IP")); v != "" {
+		return []string{hostOnly(v)}, proto
+	}
+
+	return nil, proto
+}
+
+// splitHeaderList splits the comma-separated values of a header, which may be
+// sent on several lines.
+func splitHeaderList(values []string) []string {
+	var items []string
+	for _, v := range values {
+		items = append(items, splitList(v)...)
+	}
+	return items
+}
+
+// parseForwardedElement parses the parameters of a Forwarded element, such as
+// `for="[2001:db8::1]:4711";proto=https`, into a map keyed by lower-case name.
+func parseForwardedElement(element string) map[string]string {
+	params := map[string]string{}
+
+	for _, pair := range strings.Split(element, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		params[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+
+	return params
+}
+
+// hostOnly returns the host of an address that may include a port, without
+// the brackets around an IPv6 address.
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
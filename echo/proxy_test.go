@@ -0,0 +1,235 @@
+package echo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies("loopback, 10.1.0.0/16,192.0.2.7")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if got := proxies.String(); got != "loopback,10.1.0.0/16,192.0.2.7" {
+		t.Errorf("Unexpected string %q", got)
+	}
+
+	for addr, want := range map[string]bool{
+		"127.0.0.1":        true,
+		"::1":              true,
+		"::ffff:127.0.0.1": true,
+		"10.1.2.3":         true,
+		"10.2.0.1":         false,
+		"192.0.2.7":        true,
+		"192.0.2.8":        false,
+		"not-an-ip":        false,
+	} {
+		if got := proxies.contains(addr); got != want {
+			t.Errorf("contains(%q) = %v, expected %v", addr, got, want)
+		}
+	}
+}
+
+func TestResolveClient(t *testing.T) {
+	proxies, _ := parseTrustedProxies("private")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		addr       string
+		proxies    []string
+		scheme     string
+	}{
+		{
+			name:       "Direct",
+			remoteAddr: "203.0.113.9:1234",
+			addr:       "203.0.113.9",
+			scheme:     "http",
+		},
+		{
+			name:       "UntrustedPeer",
+			remoteAddr: "203.0.113.9:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https"},
+			addr:       "203.0.113.9",
+			scheme:     "http",
+		},
+		{
+			name:       "XForwardedFor",
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.5, 10.0.0.1", "X-Forwarded-Proto": "https"},
+			addr:       "203.0.113.5",
+			proxies:    []string{"10.0.0.2", "10.0.0.1"},
+			scheme:     "https",
+		},
+		{
+			name:       "Forwarded",
+			remoteAddr: "10.0.0.2:1234",
+			headers: map[string]string{
+				"Forwarded":       `for="[2001:db8::1]:4711";proto=https, for=192.168.1.1`,
+				"X-Forwarded-For": "198.51.100.1",
+			},
+			addr:    "2001:db8::1",
+			proxies: []string{"10.0.0.2", "192.168.1.1"},
+			scheme:  "https",
+		},
+		{
+			name:       "XRealIP",
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.1"},
+			addr:       "198.51.100.1",
+			proxies:    []string{"10.0.0.2"},
+			scheme:     "http",
+		},
+		{
+			name:       "InvalidProto",
+			remoteAddr: "10.0.0.2:1234",
+			headers:    map[string]string{"X-Forwarded-Proto": "gopher"},
+			addr:       "10.0.0.2",
+			scheme:     "http",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			info := resolveClient(req, proxies)
+			if info.Addr != tt.addr || info.Scheme != tt.scheme || info.Peer != tt.remoteAddr {
+				t.Errorf("Expected %s via %s, got %+v", tt.addr, tt.scheme, info)
+			}
+			if strings.Join(info.Proxies, ",") != strings.Join(tt.proxies, ",") {
+				t.Errorf("Expected proxies %v, got %v", tt.proxies, info.Proxies)
+			}
+		})
+	}
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(command, family byte, addrs []byte) string {
+		header := append([]byte{}, proxyV2Signature...)
+		header = append(header, 0x20|command, family, 0, 0)
+		binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+		return string(append(header, addrs...))
+	}
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+
+	tests := []struct {
+		name   string
+		input  string
+		source string
+		err    string
+	}{
+		{"None", "GET / HTTP/1.1\r\n\r\n", "", ""},
+		{"Short", "GET", "", ""},
+		{"V1", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET", "192.0.2.1:56324", ""},
+		{"V1IPv6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nGET", "[2001:db8::1]:56324", ""},
+		{"V1Unknown", "PROXY UNKNOWN\r\nGET", "", ""},
+		{"V1Invalid", "PROXY TCP4 nowhere 198.51.100.1 56324 443\r\nGET", "", "invalid PROXY v1 source"},
+		{"V1TooLong", "PROXY " + strings.Repeat("x", 200), "", "too long"},
+		{"V1Incomplete", "PROX", "", "incomplete PROXY header"},
+		{"V2", v2(0x1, 0x11, ipv4) + "GET", "192.0.2.1:56324", ""},
+		{"V2Local", v2(0x0, 0x00, nil) + "GET", "", ""},
+		{"V2Truncated", v2(0x1, 0x11, ipv4[:8]) + "GET", "", "truncated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.input))
+			source, err := readProxyHeader(r)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if got := ""; source != nil {
+				got = source.String()
+				if got != tt.source {
+					t.Errorf("Expected source %q, got %q", tt.source, got)
+				}
+			} else if tt.source != "" {
+				t.Errorf("Expected source %q, got none", tt.source)
+			}
+
+			// Whatever follows the header is left for the request.
+			if rest, _ := io.ReadAll(r); tt.source != "" && string(rest) != "GET" {
+				t.Errorf("Expected the request to follow, got %q", rest)
+			}
+		})
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	cfg := testConfig(t, "TRUSTED_PROXIES=loopback", "PROXY_PROTOCOL=true")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	server := &http.Server{
+		Handler:     newClientHandler(newHandler(cfg), cfg.TrustedProxies),
+		ConnContext: proxyConnContext,
+	}
+	go server.Serve(&proxyListener{Listener: l, proxies: cfg.TrustedProxies})
+	defer server.Close()
+
+	request := func(header string) *echoedRequest {
+		t.Helper()
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+		io.WriteString(conn, header+"GET /?format=json HTTP/1.1\r\nHost: localhost\r\nX-Forwarded-For: 198.51.100.7\r\nConnection: close\r\n\r\n")
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var echo echoedRequest
+		if err := json.NewDecoder(resp.Body).Decode(&echo); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return &echo
+	}
+
+	// The PROXY source is untrusted, so its X-Forwarded-For is not believed.
+	echo := request("PROXY TCP4 203.0.113.9 127.0.0.1 40000 80\r\n")
+	if echo.ClientAddr != "203.0.113.9" {
+		t.Errorf("Expected the PROXY source as client, got %q", echo.ClientAddr)
+	}
+	if !strings.HasPrefix(echo.RemoteAddr, "127.0.0.1:") || len(echo.Proxies) != 1 {
+		t.Errorf("Expected the socket peer and one proxy, got %q %v", echo.RemoteAddr, echo.Proxies)
+	}
+
+	// Without a header, the loopback peer is a trusted proxy.
+	echo = request("")
+	if echo.ClientAddr != "198.51.100.7" {
+		t.Errorf("Expected the forwarded client, got %q", echo.ClientAddr)
+	}
+}
@@ -0,0 +1,209 @@
+package echo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// proxyHeaderTimeout is how long a connection is given to send its PROXY
+	// protocol header.
+	proxyHeaderTimeout = 5 * time.Second
+
+	// proxyV1MaxLength is the longest PROXY protocol v1 header, including
+	// the CRLF.
+	proxyV1MaxLength = 107
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener accepts connections that may start with a HAProxy PROXY
+// protocol v1 or v2 header. Headers are only accepted from trusted proxies,
+// and connections without one are served as usual.
+type proxyListener struct {
+	net.Listener
+	proxies trustedProxies
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, proxies: l.proxies, reader: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn is a connection whose PROXY protocol header, if any, is read on
+// first use, so that a slow client does not hold up Accept.
+type proxyConn struct {
+	net.Conn
+	proxies trustedProxies
+	reader  *bufio.Reader
+
+	once   sync.Once
+	source net.Addr
+	err    error
+}
+
+type proxyConnKey struct{}
+
+// proxyConnContext adds the PROXY protocol connection to the context of the
+// requests it carries, so that the socket peer can be reported. It is used as
+// http.Server.ConnContext.
+func proxyConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if pc, ok := conn.(*proxyConn); ok {
+		return context.WithValue(ctx, proxyConnKey{}, pc)
+	}
+	return ctx
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout)) // nolint:errcheck
+		defer c.Conn.SetReadDeadline(time.Time{})                  // nolint:errcheck
+
+		c.source, c.err = readProxyHeader(c.reader)
+		if c.err == nil && c.source != nil && !c.proxies.contains(hostOnly(c.Conn.RemoteAddr().String())) {
+			c.source, c.err = nil, fmt.Errorf("PROXY header from untrusted peer %s", c.Conn.RemoteAddr())
+		}
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+// proxied returns true if the connection had a PROXY protocol header giving
+// the source address.
+func (c *proxyConn) proxied() bool {
+	c.readHeader()
+	return c.source != nil
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	if c.readHeader(); c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr returns the source address given by the PROXY protocol header,
+// or the address of the socket peer if there was none.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.proxied() {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a PROXY protocol header from r, and returns the
+// source address it gives. It returns nil without consuming anything if r
+// does not start with a header, and nil after consuming the header if it
+// does not give an address, as for health checks sent by the proxy itself.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(proxyV2Signature))
+
+	switch {
+	case bytes.Equal(start, proxyV2Signature):
+		return readProxyV2Header(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return readProxyV1Header(r)
+	case err != nil && len(start) > 0 && (bytes.HasPrefix(proxyV2Signature, start) || strings.HasPrefix("PROXY ", string(start))):
+		return nil, fmt.Errorf("incomplete PROXY header: %w", err)
+	}
+
+	// Anything else, including requests shorter than a header, is served
+	// as usual.
+	return nil, nil
+}
+
+// readProxyV1Header reads a text header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1Header(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, errors.New("PROXY v1 header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("incomplete PROXY v1 header: %w", err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid PROXY v1 source %s:%s", fields[2], fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2Header reads a binary header, skipping any TLVs.
+func readProxyV2Header(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("incomplete PROXY v2 header: %w", err)
+	}
+
+	if version := header[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+	command := header[12] & 0x0f
+	family := header[13]
+
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("incomplete PROXY v2 header: %w", err)
+	}
+
+	// LOCAL connections are made by the proxy itself, and keep the address
+	// of the socket peer.
+	if command == 0x0 {
+		return nil, nil
+	}
+	if command != 0x1 {
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", command)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("PROXY v2 IPv4 addresses truncated")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("PROXY v2 IPv6 addresses truncated")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+
+	// Other families, such as UDP and UNIX sockets, do not give an address
+	// that applies to this connection.
+	return nil, nil
+}
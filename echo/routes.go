@@ -0,0 +1,256 @@
+package echo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+)
+
+// routeOff disables an endpoint when given as its path.
+const routeOff = "off"
+
+// The endpoints that can be routed. Any path that is not one of the other
+// endpoints is echoed.
+const (
+	routeEcho        = "echo"
+	routeWebSocketUI = "websocket_ui"
+	routeSSE         = "sse"
+	routeSSEPublish  = "sse_publish"
+	routeMetrics     = "metrics"
+	routeHealth      = "health"
+	routeReady       = "ready"
+	routeVersion     = "version"
+	routeRoutes      = "routes"
+
+	// routeNotFound is any path outside the prefix.
+	routeNotFound = "not_found"
+)
+
+// routeSettings maps paths to endpoints. Paths are relative to Prefix, and
+// empty paths disable their endpoint.
+type routeSettings struct {
+	// Prefix is the path the server is mounted at, without a trailing slash,
+	// or empty to serve from the root.
+	Prefix string
+
+	WebSocketUI string
+	SSE         string
+	SSEPublish  string
+	Metrics     string
+	Health      string
+	Ready       string
+	Version     string
+	Routes      string
+
+	// WebSocketPaths are the only paths on which WebSocket upgrades are
+	// accepted, and on which they are required. Paths ending in a slash match
+	// every path below them. Upgrades are accepted on any echo path if it is
+	// empty.
+	WebSocketPaths []string
+}
+
+// defaultRouteSettings returns the paths used by echo.websocket.org.
+func defaultRouteSettings() routeSettings {
+	return routeSettings{
+		WebSocketUI: "/.ws",
+		SSE:         "/.sse",
+		SSEPublish:  "/.sse/publish",
+		Metrics:     "/.metrics",
+		Health:      "/.health",
+		Ready:       "/.ready",
+		Version:     "/.version",
+		Routes:      "/.routes",
+	}
+}
+
+// endpoints returns the path of each endpoint, in the order they are matched
+// and listed.
+func (r *routeSettings) endpoints() []*string {
+	return []*string{&r.WebSocketUI, &r.SSE, &r.SSEPublish, &r.Metrics, &r.Health, &r.Ready, &r.Version, &r.Routes}
+}
+
+// endpointNames are the route names of the paths returned by endpoints.
+var endpointNames = []string{routeWebSocketUI, routeSSE, routeSSEPublish, routeMetrics, routeHealth, routeReady, routeVersion, routeRoutes}
+
+// routeDescriptions are listed by the routes endpoint.
+var routeDescriptions = map[string]string{
+	routeEcho:        "echoes the request, on any path not listed here",
+	"websocket":      "echoes WebSocket messages",
+	routeWebSocketUI: "WebSocket test page",
+	routeSSE:         "echoes the request as Server-Sent Events",
+	routeSSEPublish:  "sends a POSTed message to the SSE streams on a channel",
+	routeMetrics:     "metrics in the Prometheus text format",
+	routeHealth:      "reports that the server is alive",
+	routeReady:       "reports whether the server accepts new connections",
+	routeVersion:     "reports the version and uptime",
+	routeRoutes:      "lists these routes",
+}
+
+// setRoutePath sets path to v, a path starting with a slash or "off".
+func setRoutePath(path *string, v string) error {
+	if strings.EqualFold(v, routeOff) {
+		*path = ""
+		return nil
+	}
+	if !strings.HasPrefix(v, "/") {
+		return fmt.Errorf(`must be a path starting with "/" or %q`, routeOff)
+	}
+	*path = v
+	return nil
+}
+
+// setPathPrefix sets prefix to v, without a trailing slash.
+func setPathPrefix(prefix *string, v string) error {
+	if !strings.HasPrefix(v, "/") {
+		return errors.New(`must be a path starting with "/"`)
+	}
+	*prefix = strings.TrimRight(v, "/")
+	return nil
+}
+
+// validate checks that no two endpoints share a path.
+func (r *routeSettings) validate() error {
+	seen := map[string]string{}
+
+	for i, path := range r.endpoints() {
+		if *path == "" {
+			continue
+		}
+		if *path == "/" {
+			return fmt.Errorf("the %s endpoint cannot be served at /, which is the echo endpoint", endpointNames[i])
+		}
+		if other, ok := seen[*path]; ok {
+			return fmt.Errorf("the %s and %s endpoints are both served at %s", other, endpointNames[i], *path)
+		}
+		seen[*path] = endpointNames[i]
+	}
+
+	for _, path := range r.WebSocketPaths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("WebSocket path %q must start with \"/\"", path)
+		}
+		if name, ok := seen[path]; ok {
+			return fmt.Errorf("WebSocket path %s is the %s endpoint", path, name)
+		}
+	}
+
+	return nil
+}
+
+// path returns the full path of a relative path.
+func (r *routeSettings) path(relative string) string {
+	return r.Prefix + relative
+}
+
+// match returns the route of a request path, and the path relative to the
+// prefix.
+func (r *routeSettings) match(path string) (string, string) {
+	relative := path
+	if r.Prefix != "" {
+		switch {
+		case path == r.Prefix:
+			relative = "/"
+		case strings.HasPrefix(path, r.Prefix+"/"):
+			relative = path[len(r.Prefix):]
+		default:
+			return routeNotFound, path
+		}
+	}
+
+	for i, endpoint := range r.endpoints() {
+		if *endpoint != "" && *endpoint == relative {
+			return endpointNames[i], relative
+		}
+	}
+
+	return routeEcho, relative
+}
+
+// isWebSocketPath returns true if WebSocket upgrades are accepted on a path
+// relative to the prefix.
+func (r *routeSettings) isWebSocketPath(relative string) bool {
+	if len(r.WebSocketPaths) == 0 {
+		return true
+	}
+
+	for _, path := range r.WebSocketPaths {
+		if path == relative || (strings.HasSuffix(path, "/") && strings.HasPrefix(relative, path)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// webSocketPath returns the path the WebSocket test page connects to.
+func (r *routeSettings) webSocketPath() string {
+	if len(r.WebSocketPaths) == 0 {
+		return r.path("/")
+	}
+	return r.path(r.WebSocketPaths[0])
+}
+
+// routeEntry is an endpoint listed by the routes endpoint.
+type routeEntry struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Description string `json:"description"`
+}
+
+// list returns the active endpoints.
+func (r *routeSettings) list() []routeEntry {
+	entries := []routeEntry{{Name: routeEcho, Path: r.path("/")}}
+
+	if len(r.WebSocketPaths) == 0 {
+		entries = append(entries, routeEntry{Name: "websocket", Path: r.path("/")})
+	}
+	for _, path := range r.WebSocketPaths {
+		entries = append(entries, routeEntry{Name: "websocket", Path: r.path(path)})
+	}
+
+	for i, path := range r.endpoints() {
+		if *path != "" {
+			entries = append(entries, routeEntry{Name: endpointNames[i], Path: r.path(*path)})
+		}
+	}
+
+	for i := range entries {
+		entries[i].Description = routeDescriptions[entries[i].Name]
+	}
+
+	return entries
+}
+
+// serveRoutes lists the active endpoints, as JSON if it was requested and as
+// a text table otherwise.
+func (h *Handler) serveRoutes(wr http.ResponseWriter, req *http.Request) {
+	entries := h.config.Routes.list()
+
+	if negotiateFormat(req) == formatJSON {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		wr.Header().Set("Content-Type", formatJSON.contentType())
+		wr.Write(append(data, '\n')) // nolint:errcheck
+		return
+	}
+
+	wr.Header().Set("Content-Type", formatText.contentType())
+	writeRoutes(wr, entries)
+}
+
+// writeRoutes writes the endpoints as a table of paths, names and
+// descriptions.
+func writeRoutes(w io.Writer, entries []routeEntry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", entry.Path, entry.Name, entry.Description)
+	}
+	tw.Flush() // nolint:errcheck
+}
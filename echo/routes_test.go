@@ -0,0 +1,172 @@
+package echo
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRouteMatch(t *testing.T) {
+	cfg := testConfig(t, "PATH_PREFIX=/echo/", "SSE_PATH=/events", "METRICS_PATH=off")
+	routes := &cfg.Routes
+
+	tests := []struct {
+		path     string
+		route    string
+		relative string
+	}{
+		{"/echo", routeEcho, "/"},
+		{"/echo/", routeEcho, "/"},
+		{"/echo/anything", routeEcho, "/anything"},
+		{"/echo/events", routeSSE, "/events"},
+		{"/echo/.sse", routeEcho, "/.sse"},
+		{"/echo/.metrics", routeEcho, "/.metrics"},
+		{"/echo/.health", routeHealth, "/.health"},
+		{"/echoes", routeNotFound, "/echoes"},
+		{"/.health", routeNotFound, "/.health"},
+	}
+
+	for _, tt := range tests {
+		route, relative := routes.match(tt.path)
+		if route != tt.route || relative != tt.relative {
+			t.Errorf("match(%q) = %s %s, expected %s %s", tt.path, route, relative, tt.route, tt.relative)
+		}
+	}
+}
+
+func TestIsWebSocketPath(t *testing.T) {
+	routes := &routeSettings{WebSocketPaths: []string{"/ws", "/rooms/"}}
+
+	for path, want := range map[string]bool{
+		"/ws":        true,
+		"/ws/other":  false,
+		"/rooms/":    true,
+		"/rooms/one": true,
+		"/rooms":     false,
+		"/":          false,
+	} {
+		if got := routes.isWebSocketPath(path); got != want {
+			t.Errorf("isWebSocketPath(%q) = %v, expected %v", path, got, want)
+		}
+	}
+
+	if !(&routeSettings{}).isWebSocketPath("/anything") {
+		t.Error("Expected every path to accept upgrades by default")
+	}
+}
+
+func TestRoutesEndpoint(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "PATH_PREFIX=/echo", "METRICS_PATH=off", "WEBSOCKET_PATHS=/ws")))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/echo/.routes?format=json")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var entries []routeEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		t.Fatalf("Failed to decode routes: %v", err)
+	}
+
+	paths := map[string]string{}
+	for _, entry := range entries {
+		paths[entry.Name] = entry.Path
+		if entry.Description == "" {
+			t.Errorf("Expected %s to be described", entry.Name)
+		}
+	}
+
+	if paths[routeEcho] != "/echo/" || paths["websocket"] != "/echo/ws" || paths[routeSSE] != "/echo/.sse" {
+		t.Errorf("Unexpected routes %v", paths)
+	}
+	if _, ok := paths[routeMetrics]; ok {
+		t.Errorf("Expected the disabled metrics endpoint not to be listed, got %v", paths)
+	}
+
+	resp, err = http.Get(server.URL + "/echo/.routes")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if !strings.Contains(string(body), "/echo/.health") {
+		t.Errorf("Expected the text listing to include the health endpoint, got %q", body)
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "PATH_PREFIX=/echo")))
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, _ := get("/other"); status != http.StatusNotFound {
+		t.Errorf("Expected paths outside the prefix to be 404, got %d", status)
+	}
+
+	status, body := get("/echo/test")
+	if status != http.StatusOK || !strings.Contains(body, "GET /echo/test HTTP/1.1") {
+		t.Errorf("Expected the request to be echoed, got %d %q", status, body)
+	}
+	if !strings.Contains(body, "/echo/.ws") || !strings.Contains(body, "/echo/.sse") {
+		t.Errorf("Expected the footer links to include the prefix, got %q", body)
+	}
+
+	if status, body := get("/echo/.ws"); status != http.StatusOK || !strings.Contains(body, `var websocketPath = "/echo/"`) {
+		t.Errorf("Expected the test page to connect under the prefix, got %d", status)
+	}
+
+	if status, _ := get("/echo/.health"); status != http.StatusOK {
+		t.Errorf("Expected the health endpoint under the prefix, got %d", status)
+	}
+
+	ws, _ := dialWebSocket(t, server, "/echo/")
+	ws.Close()
+}
+
+func TestWebSocketPaths(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "WEBSOCKET_PATHS=/ws")))
+	defer server.Close()
+
+	ws, _ := dialWebSocket(t, server, "/ws")
+	ws.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/other"
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL, nil); err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected upgrades on other paths to be 404, got %v", err)
+	}
+
+	resp, err := http.Get(server.URL + "/ws")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Upgrade") != "websocket" {
+		t.Errorf("Expected 426 on the WebSocket path, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/other")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected other paths to be echoed, got %d", resp.StatusCode)
+	}
+}
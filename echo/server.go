@@ -0,0 +1,1096 @@
+package echo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	// defaultConnectionTimeoutMinutes is the default timeout for long-lived connections (WebSocket and SSE)
+	defaultConnectionTimeoutMinutes = 10
+)
+
+// serve starts the HTTP listener, and the TLS listener if it is enabled. It
+// returns when either fails, or shuts down gracefully and returns nil once ctx
+// is done.
+func serve(ctx context.Context, cfg *config) error {
+	logger := newLogger(os.Stdout, cfg)
+	logger.Info("starting echo server", "version", Version, "config", cfg)
+
+	tlsConfig, err := loadTLSConfig(cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	echo := newHandler(cfg)
+
+	var handler http.Handler = echo
+	if cfg.AccessLog.Format != accessLogOff {
+		w, err := openAccessLog(cfg.AccessLog)
+		if err != nil {
+			return fmt.Errorf("unable to open access log: %w", err)
+		}
+		handler = newAccessLogHandler(handler, w, cfg.AccessLog)
+	}
+	handler = newClientHandler(handler, cfg.TrustedProxies)
+
+	var servers []*http.Server
+	errs := make(chan error, 2)
+
+	if tlsConfig != nil {
+		listener, err := listen(cfg, cfg.TLSPort)
+		if err != nil {
+			return err
+		}
+		logger.Info("listening", "port", cfg.TLSPort, "tls", true, "proxy_protocol", cfg.ProxyProtocol)
+
+		// HTTP/2 is negotiated via ALPN by the standard library when serving
+		// TLS, so the handler does not need to be wrapped with h2c.
+		server := &http.Server{
+			Handler:     handler,
+			TLSConfig:   tlsConfig,
+			ConnContext: proxyConnContext,
+		}
+		servers = append(servers, server)
+
+		go func() {
+			errs <- server.ServeTLS(listener, "", "")
+		}()
+	}
+
+	listener, err := listen(cfg, cfg.Port)
+	if err != nil {
+		return err
+	}
+	logger.Info("listening", "port", cfg.Port, "tls", false, "proxy_protocol", cfg.ProxyProtocol)
+
+	server := &http.Server{
+		Handler: h2c.NewHandler(
+			handler,
+			&http2.Server{},
+		),
+		ConnContext: proxyConnContext,
+	}
+	servers = append(servers, server)
+
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// The listeners are closed first, so that no new connections are
+	// accepted while the open ones are asked to close.
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(drainCtx); err != nil {
+				server.Close() // nolint:errcheck
+			}
+		}(server)
+	}
+
+	echo.shutdown()
+
+	if err := echo.drain(drainCtx); err != nil {
+		logger.Warn("shutdown timed out, closing remaining connections", "websockets", echo.websockets.Load())
+	}
+	wg.Wait()
+
+	logger.Info("shutdown complete")
+	return nil
+}
+
+// listen opens a TCP listener on port, which accepts PROXY protocol headers
+// if they are enabled.
+func listen(cfg *config, port string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ProxyProtocol {
+		listener = &proxyListener{Listener: listener, proxies: cfg.TrustedProxies}
+	}
+
+	return listener, nil
+}
+
+// upgrader accepts any origin, as origins are checked against ALLOWED_ORIGINS
+// by serveWebSocket before upgrading.
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool {
+		return true
+	},
+}
+
+// Handler serves the echo endpoints. It is created by New, or by
+// echo-server with the configuration loaded at startup.
+type Handler struct {
+	config  *config
+	logger  *slog.Logger
+	metrics *metrics
+	limiter *limiter
+
+	// channels delivers messages published to SSE streams.
+	channels *sseChannels
+
+	// websocketPage is the WebSocket test page, which connects to the
+	// configured WebSocket path.
+	websocketPage string
+
+	// started is when the handler was created, reported as the uptime.
+	started time.Time
+
+	// notReady is set when the server is shutting down, so that the
+	// readiness endpoint reports it as not ready.
+	notReady atomic.Bool
+
+	// connections is the number of requests served, used to identify each
+	// connection in the logs.
+	connections atomic.Uint64
+
+	// websockets is the number of open WebSocket connections, which are
+	// waited for on shutdown.
+	websockets atomic.Int64
+
+	// shuttingDown is closed when the server starts shutting down.
+	shuttingDown chan struct{}
+	shutdownOnce sync.Once
+}
+
+func newHandler(cfg *config) *Handler {
+	return &Handler{
+		config:        cfg,
+		logger:        newLogger(os.Stdout, cfg),
+		metrics:       newMetrics(),
+		limiter:       newLimiter(cfg.Limits),
+		channels:      newSSEChannels(),
+		websocketPage: websocketPage(cfg.Routes.webSocketPath()),
+		started:       time.Now(),
+		shuttingDown:  make(chan struct{}),
+	}
+}
+
+func (h *Handler) ServeHTTP(wr http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	route, relative := h.config.Routes.match(req.URL.Path)
+
+	// Probes are answered before anything else, so that they are not echoed
+	// or logged.
+	if isProbeRoute(route) {
+		recordProbe(req)
+		h.serveProbe(wr, route)
+		return
+	}
+
+	req = withClientInfo(req, h.config.TrustedProxies)
+
+	start := time.Now()
+
+	// The test page has always accepted WebSocket connections as well.
+	transport := "http"
+	if websocket.IsWebSocketUpgrade(req) && (route == routeEcho || route == routeWebSocketUI) {
+		transport = "websocket"
+	} else if route == routeSSE {
+		transport = "sse"
+	}
+
+	client := requestClient(req)
+	log := h.logger.With(
+		"conn_id", h.connections.Add(1),
+		"remote_addr", client.Peer,
+		"client_addr", client.Addr,
+		"transport", transport,
+		"method", req.Method,
+		"path", req.URL.RequestURI(),
+	)
+
+	if h.config.LogHTTPHeaders {
+		log.Debug("request headers", "proto", req.Proto, "host", req.Host, "headers", req.Header)
+	}
+
+	if h.config.LogHTTPBody {
+		buf := &bytes.Buffer{}
+		buf.ReadFrom(req.Body) // nolint:errcheck
+
+		if buf.Len() != 0 {
+			log.Debug("request body", bodyAttrs(buf.Bytes())...)
+		}
+
+		// Replace original body with buffered version so it's still sent to the
+		// browser.
+		req.Body.Close()
+		req.Body = io.NopCloser(
+			bytes.NewReader(buf.Bytes()),
+		)
+	}
+
+	sendServerHostname := h.config.SendServerHostname
+	if v := req.Header.Get("X-Send-Server-Hostname"); v != "" {
+		sendServerHostname = !strings.EqualFold(v, "false")
+	}
+
+	// servedBy is nil if the instance should not be identified.
+	var servedBy *instanceIdentity
+	if sendServerHostname {
+		servedBy = &h.config.Instance
+		wr.Header().Set(servedByHeader, servedBy.String())
+	}
+
+	for name := range h.config.SendHeaders {
+		wr.Header().Set(name, h.config.SendHeaders.Get(name))
+	}
+
+	rec := &responseRecorder{ResponseWriter: wr}
+
+	webSocketPath := h.config.Routes.isWebSocketPath(relative)
+
+	if err := h.limiter.allowRequest(clientIP(req)); err != nil {
+		h.rejectRequest(rec, req, log, transport, err)
+	} else if route == routeNotFound {
+		http.NotFound(rec, req)
+	} else if transport == "websocket" && !webSocketPath {
+		http.Error(rec, "WebSocket upgrades are not accepted on this path", http.StatusNotFound)
+	} else if transport == "websocket" {
+		h.serveWebSocket(rec, req, log, servedBy)
+	} else if route == routeEcho && webSocketPath && len(h.config.Routes.WebSocketPaths) > 0 {
+		rec.Header().Set("Upgrade", "websocket")
+		http.Error(rec, "This path only accepts WebSocket upgrades", http.StatusUpgradeRequired)
+	} else if route == routeMetrics {
+		h.metrics.ServeHTTP(rec, req)
+	} else if route == routeRoutes {
+		h.serveRoutes(rec, req)
+	} else if route == routeWebSocketUI {
+		rec.Header().Add("Content-Type", "text/html")
+		rec.WriteHeader(200)
+		io.WriteString(rec, h.websocketPage) // nolint:errcheck
+	} else if transport == "sse" {
+		h.serveSSE(rec, req, log, servedBy)
+	} else if route == routeSSEPublish {
+		h.servePublish(rec, req, log)
+	} else {
+		h.serveHTTP(rec, req, log, servedBy)
+	}
+
+	duration := time.Since(start)
+	h.metrics.observeRequest(req.Method, rec.status, transport, duration.Seconds())
+
+	// WebSocket connections are logged when they open and close instead.
+	if transport == "websocket" {
+		return
+	}
+
+	log.Info("request",
+		"status", rec.status,
+		"bytes", rec.bytes,
+		"duration", duration,
+	)
+}
+
+func (h *Handler) serveWebSocket(wr http.ResponseWriter, req *http.Request, log *slog.Logger, servedBy *instanceIdentity) {
+	if err := checkOrigin(req, h.config.AllowedOrigins); err != nil {
+		log.Warn("origin rejected", "origin", req.Header.Get("Origin"), "error", err)
+		http.Error(wr, fmt.Sprintf("Forbidden: %s", err), http.StatusForbidden)
+		return
+	}
+
+	release, limitErr := h.limiter.acquireConnection(clientIP(req))
+	if limitErr != nil {
+		h.rejectRequest(wr, req, log, "websocket", limitErr)
+		return
+	}
+	defer release()
+
+	responseHeader := http.Header{}
+	if subprotocol := selectSubprotocol(req, h.config.WebSocketSubprotocols); subprotocol != "" {
+		responseHeader.Set("Sec-Websocket-Protocol", subprotocol)
+	}
+	if servedBy != nil {
+		responseHeader.Set(servedByHeader, servedBy.String())
+	}
+
+	compression, err := parseCompressionSettings(req, h.config.WebSocketCompression)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pingInterval, err := parsePingInterval(req.URL.Query().Get("ping_interval"))
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limits := h.config.WebSocketLimits
+
+	connectionUpgrader := upgrader
+	connectionUpgrader.EnableCompression = compression.Enabled
+	connectionUpgrader.ReadBufferSize = limits.ReadBufferSize
+	connectionUpgrader.WriteBufferSize = limits.WriteBufferSize
+
+	connection, err := connectionUpgrader.Upgrade(wr, req, responseHeader)
+	if err != nil {
+		log.Warn("websocket upgrade failed", "error", err)
+		return
+	}
+
+	defer connection.Close()
+
+	h.websockets.Add(1)
+	defer h.websockets.Add(-1)
+
+	start := time.Now()
+	var messages int
+	log.Info("websocket connected", "subprotocol", connection.Subprotocol())
+	h.metrics.connectionOpened("websocket")
+	defer func() {
+		h.metrics.connectionClosed("websocket", time.Since(start))
+		log.Info("websocket closed", "messages", messages, "duration", time.Since(start))
+		recordConnection(req, messages)
+	}()
+
+	info := &echoedWebSocket{
+		Subprotocol:    connection.Subprotocol(),
+		MaxMessageSize: limits.MaxMessageSize,
+	}
+	if h.config.Limits != (limitSettings{}) {
+		info.Limits = &h.config.Limits
+	}
+
+	// Messages over the limit are rejected by gorilla/websocket, which closes
+	// the connection with 1009 Message Too Big.
+	if limits.MaxMessageSize > 0 {
+		connection.SetReadLimit(limits.MaxMessageSize)
+	}
+
+	compressed := compression.Enabled && offersDeflate(req)
+	if compressed {
+		connection.SetCompressionLevel(compression.Level) // nolint:errcheck
+		info.Extensions = []string{deflateExtension}
+		info.CompressionLevel = compression.Level
+	}
+
+	timeout := h.config.ConnectionTimeout
+	timeoutMinutes := timeout.Minutes()
+
+	message, err := websocketGreeting(req, info, servedBy)
+	if err != nil {
+		log.Error("unable to build greeting", "error", err)
+		return
+	}
+
+	err = connection.WriteMessage(websocket.TextMessage, message)
+	if err == nil {
+		h.metrics.observeMessage("out", websocket.TextMessage, int64(len(message)))
+
+		// Create channels for communication
+		type wsMessage struct {
+			messageType int
+			message     []byte
+			// rest is the unread remainder of a message longer than
+			// streamThreshold, or nil if message holds all of it.
+			rest io.Reader
+			err  error
+		}
+		messageChan := make(chan wsMessage)
+
+		// Channel to resume reading once the remainder of a long message
+		// has been consumed
+		resume := make(chan struct{})
+		
+		// Channel to signal when to stop reading
+		done := make(chan bool)
+		defer close(done)
+
+		// Pings from the client are answered by the main loop rather than
+		// the reader, so that every write happens on this goroutine. The
+		// same applies to the reply to a close frame, which is sent when
+		// the reader reports the close error.
+		//
+		// While the remainder of a long message is read, the ping handler
+		// runs on this goroutine, which cannot also receive from pings, so
+		// it answers directly. The reader is waiting to resume then, so
+		// reading is set only by this goroutine while no other reads it.
+		pings := make(chan string)
+		var reading bool
+		connection.SetPingHandler(func(appData string) error {
+			if reading {
+				return writePong(connection, log, appData)
+			}
+			select {
+			case pings <- appData:
+			case <-done:
+			}
+			return nil
+		})
+		connection.SetPongHandler(func(appData string) error {
+			log.Debug("pong received", "payload", appData)
+			return nil
+		})
+		connection.SetCloseHandler(func(int, string) error {
+			return nil
+		})
+		
+		// Start goroutine to read messages
+		go func() {
+			for {
+				var message []byte
+				var rest io.Reader
+
+				messageType, r, err := connection.NextReader()
+				if err == nil {
+					message, rest, err = readMessageHead(r, streamThreshold)
+				}
+
+				select {
+				case messageChan <- wsMessage{messageType, message, rest, err}:
+				case <-done:
+					return
+				}
+				if err != nil {
+					return
+				}
+
+				if rest != nil {
+					select {
+					case <-resume:
+					case <-done:
+						return
+					}
+				}
+			}
+		}()
+
+		// Frames on connections using a built-in subprotocol are transformed
+		// before being echoed.
+		encode := subprotocolEncoders[connection.Subprotocol()]
+		var seq int
+
+		// Server-initiated pings are enabled by the ping_interval query
+		// parameter or the "ping" command.
+		var pingTicker *time.Ticker
+		var pingC <-chan time.Time
+		setPingInterval := func(interval time.Duration) {
+			if pingTicker != nil {
+				pingTicker.Stop()
+				pingTicker, pingC = nil, nil
+			}
+			if interval > 0 {
+				pingTicker = time.NewTicker(interval)
+				pingC = pingTicker.C
+			}
+		}
+		setPingInterval(pingInterval)
+		defer setPingInterval(0)
+
+		// Create timer for absolute timeout
+		timeoutTimer := time.NewTimer(timeout)
+		defer timeoutTimer.Stop()
+		deadline := start.Add(timeout)
+
+		// timedOut closes the connection at the timeout. The message is only
+		// sent as a text message too if no message is part way through being
+		// echoed.
+		timedOut := func(notify bool) {
+			timeoutMsg := fmt.Sprintf("Connection timeout: This connection has been closed after %.2f minutes. This server is designed for testing with use no longer than %.2f minutes.", timeoutMinutes, timeoutMinutes)
+
+			// Send timeout message as a regular text message first (for better browser compatibility)
+			if notify {
+				_ = connection.WriteMessage(websocket.TextMessage, []byte(timeoutMsg))
+			}
+
+			// Then send close frame and close the connection. Close reasons
+			// are limited to 123 bytes, so the frame only says why.
+			_ = connection.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Connection timeout"),
+				time.Now().Add(time.Second))
+
+			// Close the connection, which also stops the reader
+			connection.Close()
+
+			h.metrics.timeouts.add(1, "websocket")
+			log.Info("websocket timed out", "timeout", timeout)
+		}
+
+		closeForShutdown := func() {
+			_ = connection.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "Server shutting down"),
+				time.Now().Add(controlWriteWait))
+
+			log.Info("websocket closed for shutdown")
+		}
+
+		// readRest reads the remainder of a long message on this goroutine,
+		// which cannot watch the timer or shutdown meanwhile. The read
+		// deadline stops it at the timeout instead, and is pulled forward when
+		// the server shuts down. It returns false if the connection was
+		// closed, or should be because reading failed.
+		readRest := func(read func() error) bool {
+			stop, stopped := make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(stopped)
+				select {
+				case <-h.shuttingDown:
+					_ = connection.SetReadDeadline(time.Now())
+				case <-stop:
+				}
+			}()
+
+			_ = connection.SetReadDeadline(deadline)
+			reading = true
+			err := read()
+			reading = false
+			close(stop)
+			<-stopped
+			_ = connection.SetReadDeadline(time.Time{})
+
+			if err == nil {
+				return true
+			}
+
+			select {
+			case <-h.shuttingDown:
+				closeForShutdown()
+				return false
+			default:
+			}
+
+			if !time.Now().Before(deadline) {
+				timedOut(false)
+				return false
+			}
+
+			if errors.Is(err, errMessageTooBig) {
+				_ = connection.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseMessageTooBig, err.Error()),
+					time.Now().Add(controlWriteWait))
+			}
+			log.Info("websocket read ended", "error", err)
+			return false
+		}
+
+		for {
+			select {
+			case <-timeoutTimer.C:
+				timedOut(true)
+				return
+				
+			case <-h.shuttingDown:
+				closeForShutdown()
+				return
+
+			case t := <-pingC:
+				payload := []byte(t.Format(time.RFC3339Nano))
+				if err := connection.WriteControl(websocket.PingMessage, payload, time.Now().Add(controlWriteWait)); err != nil {
+					log.Warn("websocket write failed", "error", err)
+					return
+				}
+				log.Debug("ping sent", "payload", string(payload))
+
+			case appData := <-pings:
+				if err := writePong(connection, log, appData); err != nil {
+					log.Warn("websocket write failed", "error", err)
+					return
+				}
+
+			case msg := <-messageChan:
+				if msg.err != nil {
+					if closeErr, ok := msg.err.(*websocket.CloseError); ok {
+						// Echo the client's close code, as the default close
+						// handler would.
+						_ = connection.WriteControl(websocket.CloseMessage,
+							websocket.FormatCloseMessage(closeErr.Code, ""),
+							time.Now().Add(controlWriteWait))
+					}
+					log.Info("websocket read ended", "error", msg.err)
+					return
+				}
+
+				if msg.rest != nil {
+					if encode == nil {
+						// Relay long messages without holding them in memory.
+						var n int64
+						if !readRest(func() (err error) {
+							n, err = streamMessage(connection, msg.messageType, msg.message, msg.rest)
+							return err
+						}) {
+							return
+						}
+						resume <- struct{}{}
+
+						h.metrics.observeMessage("in", msg.messageType, n)
+						h.metrics.observeMessage("out", msg.messageType, n)
+						messages++
+						log.Info("message", "type", messageTypeName(msg.messageType), "bytes", n, "streamed", true)
+						continue
+					}
+
+					// Subprotocol encoders need the whole message, so it is
+					// buffered up to maxBufferedMessageSize.
+					var rest []byte
+					if !readRest(func() (err error) {
+						rest, err = readBuffered(msg.rest, maxBufferedMessageSize-int64(len(msg.message)))
+						return err
+					}) {
+						return
+					}
+					resume <- struct{}{}
+					msg.message = append(msg.message, rest...)
+				}
+
+				h.metrics.observeMessage("in", msg.messageType, int64(len(msg.message)))
+
+				if cmd, ok, err := parseWebSocketCommand(msg.messageType, msg.message); ok {
+					log.Info("command", "command", string(msg.message))
+
+					if err != nil {
+						reply := fmt.Sprintf("Command error: %s. Send \"%shelp\" for a list of commands.", err, commandPrefix)
+						if err := connection.WriteMessage(websocket.TextMessage, []byte(reply)); err != nil {
+							log.Warn("websocket write failed", "error", err)
+							return
+						}
+						continue
+					}
+
+					if cmd.Name == "ping" {
+						setPingInterval(cmd.Interval)
+						continue
+					}
+
+					closeConnection, err := runWebSocketCommand(connection, cmd)
+					if err != nil {
+						log.Warn("websocket command failed", "command", cmd.Name, "error", err)
+						return
+					}
+					if closeConnection {
+						return
+					}
+					continue
+				}
+
+				attrs := []any{"type", messageTypeName(msg.messageType), "bytes", len(msg.message)}
+				if compressed {
+					// Report how much permessage-deflate saves on the echoed frame.
+					attrs = append(attrs, "deflated", deflatedSize(msg.message, compression.Level))
+				}
+				if msg.messageType == websocket.TextMessage {
+					attrs = append(attrs, "data", string(msg.message))
+				}
+				messages++
+				log.Info("message", attrs...)
+
+				replyType, reply := msg.messageType, msg.message
+				if encode != nil {
+					seq++
+					replyType, reply, err = encode(seq, replyType, reply)
+					if err != nil {
+						log.Error("unable to encode message", "error", err)
+						return
+					}
+				}
+
+				if writeErr := connection.WriteMessage(replyType, reply); writeErr != nil {
+					log.Warn("websocket write failed", "error", writeErr)
+					return
+				}
+				h.metrics.observeMessage("out", replyType, int64(len(reply)))
+			}
+		}
+	}
+}
+
+func (h *Handler) serveHTTP(wr http.ResponseWriter, req *http.Request, log *slog.Logger, servedBy *instanceIdentity) {
+	control, err := parseResponseControl(req)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Delayed and streamed responses hold the connection open, so they count
+	// as connections and are closed by the connection timeout.
+	if control.slow() {
+		release, limitErr := h.limiter.acquireConnection(clientIP(req))
+		if limitErr != nil {
+			h.rejectRequest(wr, req, log, "http", limitErr)
+			return
+		}
+		defer release()
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), h.config.ConnectionTimeout)
+	defer cancel()
+	defer func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			h.metrics.timeouts.add(1, "http")
+			log.Info("http response timed out", "timeout", h.config.ConnectionTimeout)
+		}
+	}()
+
+	format := negotiateFormat(req)
+
+	var body bytes.Buffer
+	if format != formatText {
+		if err := writeStructuredEcho(&body, req, format, servedBy); err != nil {
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		writeTextEcho(&body, req, servedBy, &h.config.Routes)
+	}
+
+	control.pad(&body)
+
+	if !control.wait(ctx) {
+		return
+	}
+
+	wr.Header().Set("Content-Type", format.contentType())
+	wr.Header().Set("X-Content-Type-Options", "nosniff")
+	control.writeHeaders(wr.Header())
+	wr.WriteHeader(control.Status)
+	control.writeBody(ctx, wr, body.Bytes())
+}
+
+// writeTextEcho writes the plain text echo of req, followed by a footer with
+// links to the other endpoints.
+func writeTextEcho(w io.Writer, req *http.Request, servedBy *instanceIdentity, routes *routeSettings) {
+	if servedBy != nil {
+		fmt.Fprintf(w, "Request served by %s\n\n", servedBy)
+	}
+
+	client := requestClient(req)
+	fmt.Fprintf(w, "Client address: %s\n", client.Addr)
+	fmt.Fprintf(w, "Peer address: %s\n", client.Peer)
+	if len(client.Proxies) > 0 {
+		fmt.Fprintf(w, "Trusted proxies: %s\n", strings.Join(client.Proxies, ", "))
+	}
+	fmt.Fprintln(w, "")
+
+	// Write the echoed request first (maintaining the core functionality)
+	writeRequest(w, req)
+
+	// Get the host for dynamic URLs
+	scheme := requestScheme(req)
+	host := req.Host
+
+	// Add subtle footer with helpful links
+	fmt.Fprintln(w, "\n----------------------------------------------------------------------")
+	fmt.Fprintln(w, "         __      __   _                 _        _                    ")
+	fmt.Fprintln(w, "         \\ \\    / /__| |__  ___ ___  __| |_____| |_                  ")
+	fmt.Fprintln(w, "          \\ \\/\\/ / -_) '_ \\(_-</ _ \\/ _| / / -_)  _|                 ")
+	fmt.Fprintln(w, "           \\_/\\_/\\___|_.__//__/\\___/\\__|_\\_\\___|\\__|                 ")
+	fmt.Fprintln(w, "")
+	var links []string
+	if routes.WebSocketUI != "" {
+		links = append(links, fmt.Sprintf("WebSocket UI: %s://%s%s", scheme, host, routes.path(routes.WebSocketUI)))
+	}
+	if routes.SSE != "" {
+		links = append(links, fmt.Sprintf("SSE: %s://%s%s", scheme, host, routes.path(routes.SSE)))
+	}
+	if len(links) > 0 {
+		fmt.Fprintf(w, "  %s\n", strings.Join(links, "  |  "))
+	}
+	fmt.Fprintln(w, "  Learn more: https://websocket.org/tools/websocket-echo-server")
+	fmt.Fprintln(w, "----------------------------------------------------------------------")
+}
+
+// writeStructuredEcho writes the echo of req as a JSON or YAML document,
+// without the plain text footer.
+func writeStructuredEcho(w io.Writer, req *http.Request, format echoFormat, servedBy *instanceIdentity) error {
+	echo := newEchoedRequest(req)
+	echo.setServedBy(servedBy)
+
+	data, err := marshalEcho(format, echo, true)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func (h *Handler) serveSSE(wr http.ResponseWriter, req *http.Request, log *slog.Logger, servedBy *instanceIdentity) {
+	if _, ok := wr.(http.Flusher); !ok {
+		http.Error(wr, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+
+	release, limitErr := h.limiter.acquireConnection(clientIP(req))
+	if limitErr != nil {
+		h.rejectRequest(wr, req, log, "sse", limitErr)
+		return
+	}
+	defer release()
+
+	stream, err := parseSSEStream(req, h.config.SSERetry)
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Subscribe to the channel before the request event reports it, so that
+	// messages published as soon as it is received are delivered.
+	var messages <-chan sseMessage
+	if h.config.Routes.SSEPublish != "" {
+		if stream.Channel, err = parseSSEChannel(req.URL.Query()); err != nil {
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stream.Publish = h.config.Routes.path(h.config.Routes.SSEPublish) + "?channel=" + url.QueryEscape(stream.Channel)
+
+		var unsubscribe func()
+		messages, unsubscribe = h.channels.subscribe(stream.Channel)
+		defer unsubscribe()
+	}
+
+	timeout := h.config.ConnectionTimeout
+	timeoutMinutes := timeout.Minutes()
+
+	var echo strings.Builder
+	if format := negotiateFormat(req); format != formatText {
+		echoed := newEchoedRequest(req)
+		echoed.SSE = stream
+
+		data, err := marshalEcho(format, echoed, false)
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		echo.Write(data)
+	} else {
+		writeRequest(&echo, req)
+		stream.writeText(&echo)
+	}
+
+	wr.Header().Set("Content-Type", "text/event-stream")
+	wr.Header().Set("Cache-Control", "no-cache")
+	wr.Header().Set("Connection", "keep-alive")
+	wr.Header().Set("Access-Control-Allow-Origin", "*")
+
+	start := time.Now()
+	h.metrics.connectionOpened("sse")
+
+	// Event IDs continue from the last one the client received, if it is
+	// reconnecting.
+	id := stream.ResumeAfter
+	defer func() {
+		h.metrics.connectionClosed("sse", time.Since(start))
+		recordConnection(req, id-stream.ResumeAfter)
+	}()
+
+	// dropped returns true once the requested number of events has been
+	// sent, so that the client reconnects.
+	dropped := func() bool {
+		if stream.DropAfter > 0 && id-stream.ResumeAfter >= stream.DropAfter {
+			log.Info("sse dropped", "events", id-stream.ResumeAfter)
+			return true
+		}
+		return false
+	}
+
+	if stream.RetryMS > 0 {
+		writeSSERetry(wr, stream.RetryMS)
+	}
+
+	// Write an event about the server that is serving this request.
+	if servedBy != nil {
+		writeSSE(
+			wr,
+			log,
+			&id,
+			"server",
+			servedBy.String(),
+		)
+		if dropped() {
+			return
+		}
+	}
+
+	// Write an event that echoes back the request.
+	writeSSE(
+		wr,
+		log,
+		&id,
+		"request",
+		echo.String(),
+	)
+
+	if dropped() {
+		return
+	}
+
+	// Set up timeout timer
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	// Then send an event every interval, and a heartbeat comment every
+	// heartbeat interval if one was requested.
+	ticker := time.NewTicker(stream.interval())
+	defer ticker.Stop()
+
+	var heartbeat <-chan time.Time
+	if d := stream.heartbeat(); d > 0 {
+		heartbeatTicker := time.NewTicker(d)
+		defer heartbeatTicker.Stop()
+		heartbeat = heartbeatTicker.C
+	}
+
+	var sent int
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-h.shuttingDown:
+			writeSSE(
+				wr,
+				log,
+				&id,
+				"shutdown",
+				"Server shutting down",
+			)
+			log.Info("sse closed for shutdown")
+			return
+		case <-timer.C:
+			// Send timeout message via SSE before closing
+			timeoutMsg := fmt.Sprintf("Connection timeout: This connection has been closed after %.2f minutes. This server is designed for testing with use no longer than %.2f minutes.", timeoutMinutes, timeoutMinutes)
+			writeSSE(
+				wr,
+				log,
+				&id,
+				"error",
+				timeoutMsg,
+			)
+			h.metrics.timeouts.add(1, "sse")
+			log.Info("sse timed out", "timeout", timeout)
+			return
+		case <-heartbeat:
+			writeSSEHeartbeat(wr)
+		case msg := <-messages:
+			writeSSE(
+				wr,
+				log,
+				&id,
+				msg.Event,
+				msg.Data,
+			)
+			if dropped() {
+				return
+			}
+		case t := <-ticker.C:
+			writeSSE(
+				wr,
+				log,
+				&id,
+				stream.Event,
+				stream.data(t),
+			)
+			if dropped() {
+				return
+			}
+			if sent++; stream.Count > 0 && sent >= stream.Count {
+				log.Info("sse complete", "events", sent)
+				return
+			}
+			// Don't reset timeout - SSE should timeout after the configured duration
+			// regardless of server-sent events
+		}
+	}
+}
+
+// writeSSE sends a server-sent event and logs it at debug level.
+func writeSSE(
+	wr http.ResponseWriter,
+	log *slog.Logger,
+	id *int,
+	event, data string,
+) {
+	*id++
+	writeSSEField(wr, "event", event)
+	writeSSEField(wr, "data", data)
+	writeSSEField(wr, "id", strconv.Itoa(*id))
+	fmt.Fprintf(wr, "\n")
+	wr.(http.Flusher).Flush()
+
+	log.Debug("sse event", "event", event, "id", *id, "data", data)
+}
+
+// writeSSEField sends a single field within an event.
+func writeSSEField(
+	wr http.ResponseWriter,
+	k, v string,
+) {
+	for _, line := range strings.Split(v, "\n") {
+		fmt.Fprintf(wr, "%s: %s\n", k, line)
+	}
+}
+
+// writeRequest writes request headers to w, preceded by the TLS connection
+// details if the request arrived over TLS.
+func writeRequest(w io.Writer, req *http.Request) {
+	if req.TLS != nil {
+		writeTLS(w, req.TLS)
+		fmt.Fprintln(w, "")
+	}
+
+	fmt.Fprintf(w, "%s %s %s\n", req.Method, req.URL, req.Proto)
+	fmt.Fprintln(w, "")
+
+	fmt.Fprintf(w, "Host: %s\n", req.Host)
+	printHeaders(w, req.Header)
+
+	var body bytes.Buffer
+	io.Copy(&body, req.Body) // nolint:errcheck
+
+	if body.Len() > 0 {
+		fmt.Fprintln(w, "")
+		body.WriteTo(w) // nolint:errcheck
+	}
+}
+
+func printHeaders(w io.Writer, h http.Header) {
+	sortedKeys := make([]string, 0, len(h))
+
+	for key := range h {
+		sortedKeys = append(sortedKeys, key)
+	}
+
+	sort.Strings(sortedKeys)
+
+	for _, key := range sortedKeys {
+		for _, value := range h[key] {
+			fmt.Fprintf(w, "%s: %s\n", key, value)
+		}
+	}
+}
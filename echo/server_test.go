@@ -1,4 +1,4 @@
-package main
+package echo
 
 import (
 	"bufio"
@@ -14,7 +14,7 @@ import (
 )
 
 func TestHTTPEcho(t *testing.T) {
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t))
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -50,9 +50,7 @@ func TestHTTPEcho(t *testing.T) {
 
 func TestWebSocketTimeout(t *testing.T) {
 	// Set a short timeout for testing
-	t.Setenv("CONNECTION_TIMEOUT_MINUTES", "0.05") // 3 seconds
-
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t, "CONNECTION_TIMEOUT_MINUTES=0.05")) // 3 seconds
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -124,9 +122,7 @@ func TestWebSocketTimeout(t *testing.T) {
 
 func TestWebSocketTimeoutMessage(t *testing.T) {
 	// Set a short timeout for testing
-	t.Setenv("CONNECTION_TIMEOUT_MINUTES", "0.05") // 3 seconds
-
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t, "CONNECTION_TIMEOUT_MINUTES=0.05")) // 3 seconds
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -183,9 +179,7 @@ func TestWebSocketTimeoutMessage(t *testing.T) {
 
 func TestSSETimeout(t *testing.T) {
 	// Set a short timeout for testing
-	t.Setenv("CONNECTION_TIMEOUT_MINUTES", "0.05") // 3 seconds
-
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t, "CONNECTION_TIMEOUT_MINUTES=0.05")) // 3 seconds
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -244,9 +238,7 @@ func TestWebSocketReconnectionPrevention(t *testing.T) {
 	// This test verifies that the web UI won't auto-reconnect on timeout
 	// The actual prevention is done in the frontend JavaScript code
 	// This test just verifies the timeout happens
-	t.Setenv("CONNECTION_TIMEOUT_MINUTES", "0.05") // 3 seconds
-
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t, "CONNECTION_TIMEOUT_MINUTES=0.05")) // 3 seconds
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -293,9 +285,7 @@ func TestWebSocketReconnectionPrevention(t *testing.T) {
 
 func TestTimeoutWithActivity(t *testing.T) {
 	// Test that WebSocket timeout is NOT reset on activity (absolute timeout)
-	t.Setenv("CONNECTION_TIMEOUT_MINUTES", "0.05") // 3 seconds
-
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t, "CONNECTION_TIMEOUT_MINUTES=0.05")) // 3 seconds
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -366,10 +356,7 @@ func TestTimeoutWithActivity(t *testing.T) {
 
 func TestBackwardCompatibility(t *testing.T) {
 	// Test that WEBSOCKET_TIMEOUT_MINUTES still works when CONNECTION_TIMEOUT_MINUTES is not set
-	// Note: os.Getenv returns "" for unset variables, which our code handles correctly
-	t.Setenv("WEBSOCKET_TIMEOUT_MINUTES", "0.05") // 3 seconds
-
-	handler := http.HandlerFunc(handler)
+	handler := newHandler(testConfig(t, "WEBSOCKET_TIMEOUT_MINUTES=0.05")) // 3 seconds
 	server := httptest.NewServer(handler)
 	defer server.Close()
 
@@ -438,11 +425,12 @@ func TestHostnameOption(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			var env []string
 			if tt.envVar != "" {
-				t.Setenv("SEND_SERVER_HOSTNAME", tt.envVar)
+				env = append(env, "SEND_SERVER_HOSTNAME="+tt.envVar)
 			}
 
-			handler := http.HandlerFunc(handler)
+			handler := newHandler(testConfig(t, env...))
 			server := httptest.NewServer(handler)
 			defer server.Close()
 
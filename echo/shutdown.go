@@ -0,0 +1,44 @@
+package echo
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// defaultShutdownTimeout is how long connections are given to close on
+	// shutdown when SHUTDOWN_TIMEOUT_SECONDS is not set.
+	defaultShutdownTimeout = 10 * time.Second
+
+	// shutdownPollInterval is how often the open WebSocket connections are
+	// checked while draining.
+	shutdownPollInterval = 50 * time.Millisecond
+)
+
+// shutdown starts a graceful shutdown. The readiness endpoint starts failing,
+// WebSocket clients are sent a 1001 Going Away close frame and SSE clients a
+// final "shutdown" event.
+func (h *Handler) shutdown() {
+	h.setReady(false)
+	h.shutdownOnce.Do(func() {
+		close(h.shuttingDown)
+	})
+}
+
+// drain waits until every WebSocket connection has closed, or ctx is done.
+// Other requests, including SSE, are waited for by http.Server.Shutdown, but
+// WebSocket connections are hijacked from the server and so are tracked here.
+func (h *Handler) drain(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for h.websockets.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
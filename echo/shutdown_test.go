@@ -0,0 +1,159 @@
+package echo
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestShutdownClosesWebSockets(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ws, _ := dialWebSocket(t, server, "/")
+
+	handler.shutdown()
+
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("Expected close 1001, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := handler.drain(ctx); err != nil {
+		t.Errorf("Expected WebSocket connections to drain, got %v", err)
+	}
+}
+
+func TestShutdownEndsSSE(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/.sse")
+	if err != nil {
+		t.Fatalf("Failed to connect to SSE: %v", err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+
+	// Wait for the request event before shutting down.
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		if line == "event: request\n" {
+			break
+		}
+	}
+
+	handler.shutdown()
+
+	var events []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		if strings.HasPrefix(line, "event: ") {
+			events = append(events, strings.TrimSpace(strings.TrimPrefix(line, "event: ")))
+		}
+	}
+
+	if len(events) == 0 || events[len(events)-1] != "shutdown" {
+		t.Errorf("Expected the stream to end with a shutdown event, got %v", events)
+	}
+}
+
+func TestShutdownReadiness(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	handler.shutdown()
+	handler.shutdown()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/.ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readiness to fail after shutdown, got %d", rec.Code)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	handler.websockets.Add(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := handler.drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected drain to time out, got %v", err)
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	// Find a free port for the server to listen on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cfg := testConfig(t, "PORT="+strconv.Itoa(port), "LOG_LEVEL=error")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, cfg)
+	}()
+
+	// Wait for the listener to start.
+	url := "ws://127.0.0.1:" + cfg.Port + "/"
+	var ws *websocket.Conn
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		ws, _, err = websocket.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Failed to connect to port %d: %v", port, err)
+		}
+	}
+	defer ws.Close()
+
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatalf("Failed to read greeting: %v", err)
+	}
+
+	cancel()
+
+	_, _, err = ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("Expected close 1001, got %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the server to shut down")
+	}
+}
@@ -0,0 +1,306 @@
+package echo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultSSEInterval is how often an SSE stream sends an event.
+	defaultSSEInterval = time.Second
+
+	// defaultSSEEvent is the name of the events sent every interval.
+	defaultSSEEvent = "time"
+
+	// minSSEInterval is the shortest interval, and heartbeat interval, a
+	// client may request.
+	minSSEInterval = 10 * time.Millisecond
+
+	// maxSSESize is the largest payload a client may request for each event,
+	// across all of its lines, and maxSSELines the most lines.
+	maxSSESize  = 1 << 20
+	maxSSELines = 1000
+
+	// maxSSERate is the most bytes of data per second a client may request,
+	// so that a single stream cannot use much of the server's bandwidth.
+	maxSSERate = 256 * 1024
+)
+
+// The payloads that can be sent instead of the time.
+const (
+	ssePayloadRepeat = "repeat"
+	ssePayloadRandom = "random"
+)
+
+// ssePayloadAlphabet is repeated, or sampled at random, to make payloads.
+// It contains only characters that need no escaping in an event.
+const ssePayloadAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// echoedSSE describes the settings of an SSE stream, which are reported in
+// its request event.
+type echoedSSE struct {
+	// LastEventID is the Last-Event-ID sent by a reconnecting client, or the
+	// last_event_id query parameter for clients that cannot set headers.
+	LastEventID string `json:"last_event_id,omitempty"`
+
+	// ResumeAfter is the ID numbering continues after, which is LastEventID
+	// if it is a number and 0 otherwise.
+	ResumeAfter int `json:"resume_after"`
+
+	// RetryMS is the reconnection delay sent in the retry field, if any.
+	RetryMS int64 `json:"retry_ms,omitempty"`
+
+	// DropAfter is the number of events after which the connection is
+	// dropped, so that the client reconnects, or 0 to keep it open.
+	DropAfter int `json:"drop_after,omitempty"`
+
+	// IntervalMS is how often an event named Event is sent.
+	IntervalMS int64  `json:"interval_ms"`
+	Event      string `json:"event"`
+
+	// Count is the number of events named Event sent before the stream is
+	// closed cleanly, or 0 to send them until the connection times out.
+	Count int `json:"count,omitempty"`
+
+	// Size is the number of bytes of Payload in each line of the events, or 0
+	// to send the time instead.
+	Size    int    `json:"size,omitempty"`
+	Payload string `json:"payload,omitempty"`
+
+	// Lines is the number of lines of data in each event.
+	Lines int `json:"lines"`
+
+	// HeartbeatMS is how often a ": ping" comment is sent, if at all.
+	HeartbeatMS int64 `json:"heartbeat_ms,omitempty"`
+
+	// Channel is the channel the stream is subscribed to, and Publish the
+	// path to which messages for it are POSTed, if publishing is enabled.
+	Channel string `json:"channel,omitempty"`
+	Publish string `json:"publish,omitempty"`
+}
+
+// parseSSEStream reads the settings of an SSE request from its query
+// parameters and Last-Event-ID header. The retry query parameter overrides
+// the configured retry, and may be "0" or "off" to send none.
+func parseSSEStream(req *http.Request, retry time.Duration) (*echoedSSE, error) {
+	query := req.URL.Query()
+
+	stream := &echoedSSE{
+		LastEventID: req.Header.Get("Last-Event-ID"),
+		IntervalMS:  defaultSSEInterval.Milliseconds(),
+		Event:       defaultSSEEvent,
+		Lines:       1,
+	}
+	if stream.LastEventID == "" {
+		stream.LastEventID = query.Get("last_event_id")
+	}
+	if id, err := strconv.Atoi(stream.LastEventID); err == nil && id > 0 {
+		stream.ResumeAfter = id
+	}
+
+	switch v := query.Get("retry"); v {
+	case "":
+	case "0", "off":
+		retry = 0
+	default:
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid retry %q: must be a positive duration, \"0\" or \"off\"", v)
+		}
+		retry = d
+	}
+	stream.RetryMS = retry.Milliseconds()
+
+	var err error
+
+	if stream.DropAfter, err = parseSSECount(query, "drop_after", 1, 0); err != nil {
+		return nil, err
+	}
+	if stream.Count, err = parseSSECount(query, "count", 1, 0); err != nil {
+		return nil, err
+	}
+	if stream.Size, err = parseSSECount(query, "size", 1, maxSSESize); err != nil {
+		return nil, err
+	}
+	if query.Get("lines") != "" {
+		if stream.Lines, err = parseSSECount(query, "lines", 1, maxSSELines); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := query.Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < minSSEInterval {
+			return nil, fmt.Errorf("invalid interval %q: must be a duration of at least %s", v, minSSEInterval)
+		}
+		stream.IntervalMS = d.Milliseconds()
+	}
+
+	if v := query.Get("heartbeat"); v != "" && v != "0" && v != "off" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < minSSEInterval {
+			return nil, fmt.Errorf("invalid heartbeat %q: must be a duration of at least %s, \"0\" or \"off\"", v, minSSEInterval)
+		}
+		stream.HeartbeatMS = d.Milliseconds()
+	}
+
+	if v := query.Get("event"); v != "" {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("invalid event %q: must be a single line", v)
+		}
+		stream.Event = v
+	}
+
+	if v := query.Get("payload"); v != "" || stream.Size > 0 {
+		switch v {
+		case "", ssePayloadRepeat:
+			stream.Payload = ssePayloadRepeat
+		case ssePayloadRandom:
+			stream.Payload = ssePayloadRandom
+		default:
+			return nil, fmt.Errorf("invalid payload %q: must be %q or %q", v, ssePayloadRepeat, ssePayloadRandom)
+		}
+		if stream.Size == 0 {
+			return nil, errors.New("invalid payload: size must be set")
+		}
+		if stream.Size*stream.Lines > maxSSESize {
+			return nil, fmt.Errorf("invalid size %d with %d lines: events must be at most %d bytes", stream.Size, stream.Lines, maxSSESize)
+		}
+	}
+
+	if rate := stream.rate(); rate > maxSSERate {
+		return nil, fmt.Errorf("invalid stream: %d bytes every %s is %.0f bytes per second, more than the limit of %d", stream.eventSize(), stream.interval(), rate, maxSSERate)
+	}
+
+	return stream, nil
+}
+
+// eventSize returns the number of bytes of data in each event, which holds
+// the time if no payload size was set.
+func (s *echoedSSE) eventSize() int {
+	lineSize := s.Size
+	if lineSize == 0 {
+		lineSize = len(time.RFC3339)
+	}
+	return lineSize * s.Lines
+}
+
+// rate returns the number of bytes of data the stream sends per second.
+func (s *echoedSSE) rate() float64 {
+	return float64(s.eventSize()) / s.interval().Seconds()
+}
+
+// parseSSECount parses the query parameter name as an integer of at least
+// min and, if max is not 0, at most max. It returns 0 if it is not set.
+func parseSSECount(query url.Values, name string, min, max int) (int, error) {
+	v := query.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || (max > 0 && n > max) {
+		if max > 0 {
+			return 0, fmt.Errorf("invalid %s %q: must be a number from %d to %d", name, v, min, max)
+		}
+		return 0, fmt.Errorf("invalid %s %q: must be a number of at least %d", name, v, min)
+	}
+
+	return n, nil
+}
+
+// interval returns how often an event is sent.
+func (s *echoedSSE) interval() time.Duration {
+	return time.Duration(s.IntervalMS) * time.Millisecond
+}
+
+// heartbeat returns how often a comment is sent, or 0 if none are.
+func (s *echoedSSE) heartbeat() time.Duration {
+	return time.Duration(s.HeartbeatMS) * time.Millisecond
+}
+
+// data returns the data of an event sent at t: the time, or a payload of Size
+// bytes, on each of Lines lines. A repeating payload is the same on every
+// line, and a random one differs on each.
+func (s *echoedSSE) data(t time.Time) string {
+	if s.Size == 0 {
+		return strings.TrimSuffix(strings.Repeat(t.Format(time.RFC3339)+"\n", s.Lines), "\n")
+	}
+
+	if s.Payload != ssePayloadRandom {
+		payload := make([]byte, s.Size)
+		for i := range payload {
+			payload[i] = ssePayloadAlphabet[i%len(ssePayloadAlphabet)]
+		}
+		return strings.TrimSuffix(strings.Repeat(string(payload)+"\n", s.Lines), "\n")
+	}
+
+	data := make([]byte, 0, (s.Size+1)*s.Lines)
+	for line := 0; line < s.Lines; line++ {
+		if line > 0 {
+			data = append(data, '\n')
+		}
+		for i := 0; i < s.Size; i++ {
+			data = append(data, ssePayloadAlphabet[rand.Intn(len(ssePayloadAlphabet))]) // nolint:gosec
+		}
+	}
+	return string(data)
+}
+
+// writeText writes the settings that differ from a new stream with the
+// defaults to the text echo of the request.
+func (s *echoedSSE) writeText(w io.Writer) {
+	var lines []string
+
+	if s.LastEventID != "" {
+		lines = append(lines, fmt.Sprintf("Last-Event-ID: %s (resuming after event %d)", s.LastEventID, s.ResumeAfter))
+	}
+	if s.RetryMS > 0 {
+		lines = append(lines, fmt.Sprintf("Retry: %dms", s.RetryMS))
+	}
+	if s.interval() != defaultSSEInterval || s.Event != defaultSSEEvent {
+		lines = append(lines, fmt.Sprintf("Sending %q events every %s", s.Event, s.interval()))
+	}
+	if s.Size > 0 {
+		lines = append(lines, fmt.Sprintf("Payload: %d %s byte(s) per line", s.Size, s.Payload))
+	}
+	if s.Lines > 1 {
+		lines = append(lines, fmt.Sprintf("Lines per event: %d", s.Lines))
+	}
+	if s.Count > 0 {
+		lines = append(lines, fmt.Sprintf("Closing after %d event(s)", s.Count))
+	}
+	if s.HeartbeatMS > 0 {
+		lines = append(lines, fmt.Sprintf("Heartbeat: every %s", s.heartbeat()))
+	}
+	if s.DropAfter > 0 {
+		lines = append(lines, fmt.Sprintf("Dropping the connection after %d event(s)", s.DropAfter))
+	}
+	if s.Channel != "" {
+		lines = append(lines, fmt.Sprintf("Channel: %s (POST messages to %s)", s.Channel, s.Publish))
+	}
+
+	if len(lines) > 0 {
+		fmt.Fprintf(w, "\n%s\n", strings.Join(lines, "\n"))
+	}
+}
+
+// writeSSERetry sends the reconnection delay, in a block of its own.
+func writeSSERetry(wr http.ResponseWriter, retryMS int64) {
+	fmt.Fprintf(wr, "retry: %d\n\n", retryMS)
+	wr.(http.Flusher).Flush()
+}
+
+// writeSSEHeartbeat sends a comment, which clients ignore, to keep the
+// connection open through proxies that close idle connections.
+func writeSSEHeartbeat(wr http.ResponseWriter) {
+	fmt.Fprintf(wr, ": ping\n\n")
+	wr.(http.Flusher).Flush()
+}
@@ -0,0 +1,267 @@
+package echo
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent is a single event, or a block with only a retry field or a
+// comment, read from an SSE stream.
+type sseEvent struct {
+	Event   string
+	Data    string
+	ID      string
+	Retry   string
+	Comment string
+}
+
+// readSSEEvents reads n events from r, or every event until the stream ends if
+// n is 0.
+func readSSEEvents(t *testing.T, r io.Reader, n int) []sseEvent {
+	t.Helper()
+
+	var (
+		events []sseEvent
+		event  sseEvent
+		data   []string
+	)
+
+	reader := bufio.NewReader(r)
+	for n == 0 || len(events) < n {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && n == 0 {
+			return events
+		} else if err != nil {
+			t.Fatalf("Failed to read event %d: %v", len(events)+1, err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			event.Data = strings.Join(data, "\n")
+			events = append(events, event)
+			event, data = sseEvent{}, nil
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "":
+			event.Comment = value
+		case "event":
+			event.Event = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			event.ID = value
+		case "retry":
+			event.Retry = value
+		}
+	}
+
+	return events
+}
+
+// getSSE opens an SSE stream, failing the test unless it is accepted.
+func getSSE(t *testing.T, server *httptest.Server, path string, header http.Header) *http.Response {
+	t.Helper()
+
+	req, _ := http.NewRequest("GET", server.URL+path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to connect to SSE: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+
+	return resp
+}
+
+func TestSSELastEventID(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "SEND_SERVER_HOSTNAME=false")))
+	defer server.Close()
+
+	resp := getSSE(t, server, "/.sse", http.Header{"Last-Event-Id": {"41"}})
+	defer resp.Body.Close()
+
+	events := readSSEEvents(t, resp.Body, 2)
+	if events[0].Event != "request" || events[0].ID != "42" || events[1].ID != "43" {
+		t.Errorf("Expected numbering to resume after 41, got %+v", events)
+	}
+	if !strings.Contains(events[0].Data, "Last-Event-ID: 41 (resuming after event 41)") {
+		t.Errorf("Expected the request event to report the Last-Event-ID, got %q", events[0].Data)
+	}
+}
+
+func TestSSELastEventIDQuery(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "SEND_SERVER_HOSTNAME=false")))
+	defer server.Close()
+
+	resp := getSSE(t, server, "/.sse?format=json&last_event_id=abc", nil)
+	defer resp.Body.Close()
+
+	events := readSSEEvents(t, resp.Body, 1)
+	if events[0].ID != "1" {
+		t.Errorf("Expected numbering to restart after an invalid ID, got %q", events[0].ID)
+	}
+
+	var echo echoedRequest
+	if err := json.Unmarshal([]byte(events[0].Data), &echo); err != nil {
+		t.Fatalf("Failed to decode request event: %v", err)
+	}
+	if echo.SSE == nil || echo.SSE.LastEventID != "abc" || echo.SSE.ResumeAfter != 0 {
+		t.Errorf("Unexpected SSE settings %+v", echo.SSE)
+	}
+}
+
+func TestSSERetry(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "SSE_RETRY_SECONDS=2.5")))
+	defer server.Close()
+
+	resp := getSSE(t, server, "/.sse", nil)
+	events := readSSEEvents(t, resp.Body, 1)
+	resp.Body.Close()
+	if events[0].Retry != "2500" || events[0].Event != "" {
+		t.Errorf("Expected a retry block first, got %+v", events[0])
+	}
+
+	resp = getSSE(t, server, "/.sse?retry=100ms", nil)
+	events = readSSEEvents(t, resp.Body, 1)
+	resp.Body.Close()
+	if events[0].Retry != "100" {
+		t.Errorf("Expected the query to override the retry, got %+v", events[0])
+	}
+
+	resp = getSSE(t, server, "/.sse?retry=off", nil)
+	events = readSSEEvents(t, resp.Body, 1)
+	resp.Body.Close()
+	if events[0].Retry != "" {
+		t.Errorf("Expected no retry, got %+v", events[0])
+	}
+}
+
+func TestSSEDropAfter(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	resp := getSSE(t, server, "/.sse?drop_after=3", http.Header{"Last-Event-Id": {"10"}})
+	defer resp.Body.Close()
+
+	events := readSSEEvents(t, resp.Body, 0)
+	if len(events) != 3 {
+		t.Fatalf("Expected the stream to end after 3 events, got %+v", events)
+	}
+	if events[0].Event != "server" || events[1].Event != "request" || events[2].Event != "time" || events[2].ID != "13" {
+		t.Errorf("Unexpected events %+v", events)
+	}
+}
+
+func TestSSEInvalidParameters(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	for _, query := range []string{
+		"retry=soon", "retry=-1s", "drop_after=0", "drop_after=x",
+		"interval=1ms", "interval=often", "count=0", "size=-1", "size=2000000",
+		"size=1048576&lines=1000", "size=2048&lines=1000",
+		"size=1048576&interval=10ms", "size=1024&lines=100&interval=100ms", "lines=1000&interval=10ms",
+		"lines=0", "heartbeat=1ms", "payload=zeros&size=10", "payload=random",
+	} {
+		resp, err := http.Get(server.URL + "/.sse?" + query)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestSSEFiniteStream(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "SEND_SERVER_HOSTNAME=false")))
+	defer server.Close()
+
+	start := time.Now()
+	resp := getSSE(t, server, "/.sse?interval=20ms&event=tick&count=3", nil)
+	defer resp.Body.Close()
+
+	events := readSSEEvents(t, resp.Body, 0)
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Expected the stream to use the requested interval, took %s", time.Since(start))
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("Expected the request and 3 ticks, got %+v", events)
+	}
+	if !strings.Contains(events[0].Data, `Sending "tick" events every 20ms`) || !strings.Contains(events[0].Data, "Closing after 3 event(s)") {
+		t.Errorf("Expected the request event to report the stream, got %q", events[0].Data)
+	}
+	for _, event := range events[1:] {
+		if _, err := time.Parse(time.RFC3339, event.Data); event.Event != "tick" || err != nil {
+			t.Errorf("Expected a tick with the time, got %+v", event)
+		}
+	}
+}
+
+func TestSSEPayload(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "SEND_SERVER_HOSTNAME=false")))
+	defer server.Close()
+
+	resp := getSSE(t, server, "/.sse?interval=10ms&count=1&size=70&lines=3", nil)
+	events := readSSEEvents(t, resp.Body, 0)
+	resp.Body.Close()
+
+	want := ssePayloadAlphabet + ssePayloadAlphabet[:8]
+	if lines := strings.Split(events[1].Data, "\n"); len(lines) != 3 || lines[0] != want || lines[2] != want {
+		t.Errorf("Expected 3 lines of the repeating payload, got %q", events[1].Data)
+	}
+
+	resp = getSSE(t, server, "/.sse?interval=10ms&count=2&size=32&payload=random&format=json", nil)
+	events = readSSEEvents(t, resp.Body, 0)
+	resp.Body.Close()
+
+	if len(events[1].Data) != 32 || len(events[2].Data) != 32 || events[1].Data == events[2].Data {
+		t.Errorf("Expected distinct random payloads of 32 bytes, got %q and %q", events[1].Data, events[2].Data)
+	}
+
+	random := (&echoedSSE{Size: 32, Payload: ssePayloadRandom, Lines: 2}).data(time.Now())
+	if lines := strings.Split(random, "\n"); len(lines) != 2 || len(lines[0]) != 32 || lines[0] == lines[1] {
+		t.Errorf("Expected each line of a random payload to differ, got %q", random)
+	}
+
+	var echo echoedRequest
+	if err := json.Unmarshal([]byte(events[0].Data), &echo); err != nil {
+		t.Fatalf("Failed to decode request event: %v", err)
+	}
+	if echo.SSE == nil || echo.SSE.Size != 32 || echo.SSE.Payload != ssePayloadRandom || echo.SSE.Count != 2 || echo.SSE.IntervalMS != 10 {
+		t.Errorf("Unexpected SSE settings %+v", echo.SSE)
+	}
+}
+
+func TestSSEHeartbeat(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "SEND_SERVER_HOSTNAME=false")))
+	defer server.Close()
+
+	resp := getSSE(t, server, "/.sse?heartbeat=20ms", nil)
+	defer resp.Body.Close()
+
+	// The request event is followed by heartbeats well before the first tick.
+	events := readSSEEvents(t, resp.Body, 3)
+	if events[1].Comment != "ping" || events[2].Comment != "ping" || events[1].ID != "" {
+		t.Errorf("Expected ping comments, got %+v", events)
+	}
+}
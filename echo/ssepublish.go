@@ -0,0 +1,203 @@
+package echo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultSSEMessageEvent is the name of the events that published
+	// messages are sent as.
+	defaultSSEMessageEvent = "message"
+
+	// maxSSEChannelLength is the longest channel name a client may choose.
+	maxSSEChannelLength = 128
+
+	// sseChannelBuffer is the number of published messages that may wait to
+	// be sent on a stream. Messages published to a stream with a full buffer
+	// are not delivered to it.
+	sseChannelBuffer = 16
+)
+
+// sseMessage is a message published to the streams on a channel.
+type sseMessage struct {
+	Event string
+	Data  string
+}
+
+// sseChannels delivers published messages to the SSE streams subscribed to
+// each channel.
+type sseChannels struct {
+	mu      sync.Mutex
+	streams map[string]map[chan sseMessage]struct{}
+}
+
+func newSSEChannels() *sseChannels {
+	return &sseChannels{streams: map[string]map[chan sseMessage]struct{}{}}
+}
+
+// subscribe returns a channel of the messages published to a channel, and a
+// function that unsubscribes from it.
+func (c *sseChannels) subscribe(channel string) (<-chan sseMessage, func()) {
+	messages := make(chan sseMessage, sseChannelBuffer)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.streams[channel] == nil {
+		c.streams[channel] = map[chan sseMessage]struct{}{}
+	}
+	c.streams[channel][messages] = struct{}{}
+
+	return messages, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		delete(c.streams[channel], messages)
+		if len(c.streams[channel]) == 0 {
+			delete(c.streams, channel)
+		}
+	}
+}
+
+// publish sends a message to every stream subscribed to a channel. It
+// returns the number of streams subscribed, and the number the message was
+// delivered to, which is fewer if the buffers of some were full.
+func (c *sseChannels) publish(channel string, msg sseMessage) (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delivered := 0
+	for messages := range c.streams[channel] {
+		select {
+		case messages <- msg:
+			delivered++
+		default:
+		}
+	}
+
+	return len(c.streams[channel]), delivered
+}
+
+// parseSSEChannel returns the channel query parameter, or a new random
+// channel name if it is not set.
+func parseSSEChannel(query url.Values) (string, error) {
+	channel := query.Get("channel")
+	if channel == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(b), nil
+	}
+
+	if len(channel) > maxSSEChannelLength {
+		return "", fmt.Errorf("invalid channel: must be at most %d bytes", maxSSEChannelLength)
+	}
+
+	return channel, nil
+}
+
+// ssePublished is the response to a published message. Streams is the
+// number of streams subscribed to the channel, of which the message was
+// delivered to Delivered and dropped by Dropped.
+type ssePublished struct {
+	Channel   string `json:"channel"`
+	Event     string `json:"event"`
+	Bytes     int    `json:"bytes"`
+	Streams   int    `json:"streams"`
+	Delivered int    `json:"delivered"`
+	Dropped   int    `json:"dropped"`
+}
+
+// servePublish sends the body of a POST request as an event on the SSE
+// streams subscribed to the channel in its query. It responds with 404 if no
+// stream on this server is subscribed to the channel, and with 503 if every
+// stream that is had too many messages waiting to take it.
+func (h *Handler) servePublish(wr http.ResponseWriter, req *http.Request, log *slog.Logger) {
+	if req.Method != http.MethodPost {
+		wr.Header().Set("Allow", http.MethodPost)
+		http.Error(wr, "Messages must be sent with POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+
+	channel := query.Get("channel")
+	if channel == "" {
+		http.Error(wr, "missing channel: set the channel query parameter to that of an SSE stream", http.StatusBadRequest)
+		return
+	}
+
+	event := query.Get("event")
+	if event == "" {
+		event = defaultSSEMessageEvent
+	} else if strings.ContainsAny(event, "\r\n") {
+		http.Error(wr, fmt.Sprintf("invalid event %q: must be a single line", event), http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(wr, req.Body, maxSSESize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(wr, fmt.Sprintf("message too large: must be at most %d bytes", maxSSESize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Carriage returns end lines in SSE, so each line ending is sent as a
+	// newline for the data to arrive unchanged.
+	data := strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(string(body))
+
+	published := ssePublished{Channel: channel, Event: event, Bytes: len(body)}
+	published.Streams, published.Delivered = h.channels.publish(channel, sseMessage{Event: event, Data: data})
+	published.Dropped = published.Streams - published.Delivered
+
+	if published.Streams == 0 {
+		log.Debug("sse message not published", "channel", channel, "event", event)
+		http.Error(wr, fmt.Sprintf("no SSE stream on this server is subscribed to channel %q", channel), http.StatusNotFound)
+		return
+	}
+
+	status := http.StatusAccepted
+	if published.Dropped > 0 {
+		log.Warn("sse message dropped", "channel", channel, "event", event, "streams", published.Streams, "dropped", published.Dropped)
+	} else {
+		log.Debug("sse message published", "channel", channel, "event", event, "streams", published.Streams)
+	}
+	if published.Delivered == 0 {
+		wr.Header().Set("Retry-After", "1")
+		status = http.StatusServiceUnavailable
+	}
+
+	if negotiateFormat(req) == formatJSON {
+		data, err := json.MarshalIndent(published, "", "  ")
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		wr.Header().Set("Content-Type", formatJSON.contentType())
+		wr.WriteHeader(status)
+		wr.Write(append(data, '\n')) // nolint:errcheck
+		return
+	}
+
+	wr.Header().Set("Content-Type", formatText.contentType())
+	wr.WriteHeader(status)
+	fmt.Fprintf(wr, "Sent %q event of %d byte(s) to %d of %d stream(s) on channel %s\n", event, published.Bytes, published.Delivered, published.Streams, channel)
+	if published.Dropped > 0 {
+		fmt.Fprintf(wr, "Dropped by %d stream(s) with %d messages waiting to be sent\n", published.Dropped, sseChannelBuffer)
+	}
+}
@@ -0,0 +1,139 @@
+package echo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSSEChannels(t *testing.T) {
+	channels := newSSEChannels()
+
+	one, unsubscribeOne := channels.subscribe("a")
+	_, unsubscribeTwo := channels.subscribe("a")
+	defer unsubscribeTwo()
+
+	if streams, delivered := channels.publish("a", sseMessage{Event: "message", Data: "hello"}); streams != 2 || delivered != 2 {
+		t.Errorf("Expected delivery to 2 streams, got %d of %d", delivered, streams)
+	}
+	if msg := <-one; msg.Data != "hello" {
+		t.Errorf("Expected the published message, got %+v", msg)
+	}
+	if streams, _ := channels.publish("b", sseMessage{}); streams != 0 {
+		t.Errorf("Expected no streams on other channels, got %d", streams)
+	}
+
+	unsubscribeOne()
+	if streams, delivered := channels.publish("a", sseMessage{}); streams != 1 || delivered != 1 {
+		t.Errorf("Expected delivery to the remaining stream, got %d of %d", delivered, streams)
+	}
+
+	// The remaining stream has not read any messages, so its buffer fills.
+	for i := 2; i < sseChannelBuffer; i++ {
+		channels.publish("a", sseMessage{})
+	}
+	if streams, delivered := channels.publish("a", sseMessage{}); streams != 1 || delivered != 0 {
+		t.Errorf("Expected the message to be dropped by the full stream, got %d of %d", delivered, streams)
+	}
+
+	unsubscribeTwo()
+	if len(channels.streams) != 0 {
+		t.Errorf("Expected empty channels to be removed, got %v", channels.streams)
+	}
+}
+
+func TestSSEPublish(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "SEND_SERVER_HOSTNAME=false")))
+	defer server.Close()
+
+	resp := getSSE(t, server, "/.sse?format=json&interval=1m", nil)
+	defer resp.Body.Close()
+
+	events := readSSEEvents(t, resp.Body, 1)
+
+	var echo echoedRequest
+	if err := json.Unmarshal([]byte(events[0].Data), &echo); err != nil {
+		t.Fatalf("Failed to decode request event: %v", err)
+	}
+	if echo.SSE == nil || echo.SSE.Channel == "" || echo.SSE.Publish != "/.sse/publish?channel="+echo.SSE.Channel {
+		t.Fatalf("Expected the request event to report the channel, got %+v", echo.SSE)
+	}
+
+	publish, err := http.Post(server.URL+echo.SSE.Publish+"&event=chat", "text/plain", strings.NewReader("hello\r\nworld"))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	publish.Body.Close()
+	if publish.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected 202, got %d", publish.StatusCode)
+	}
+
+	events = readSSEEvents(t, resp.Body, 1)
+	if events[0].Event != "chat" || events[0].Data != "hello\nworld" || events[0].ID != "2" {
+		t.Errorf("Expected the published message as the next event, got %+v", events[0])
+	}
+}
+
+func TestSSEPublishDropped(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	_, unsubscribe := handler.channels.subscribe("slow")
+	defer unsubscribe()
+	for i := 0; i < sseChannelBuffer; i++ {
+		handler.channels.publish("slow", sseMessage{})
+	}
+
+	resp, err := http.Post(server.URL+"/.sse/publish?channel=slow&format=json", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Expected 503 with Retry-After, got %d", resp.StatusCode)
+	}
+
+	var published ssePublished
+	if err := json.NewDecoder(resp.Body).Decode(&published); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if published.Streams != 1 || published.Delivered != 0 || published.Dropped != 1 {
+		t.Errorf("Expected the drop to be reported, got %+v", published)
+	}
+}
+
+func TestSSEPublishErrors(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		status int
+	}{
+		{"Get", "GET", "?channel=a", http.StatusMethodNotAllowed},
+		{"MissingChannel", "POST", "", http.StatusBadRequest},
+		{"InvalidEvent", "POST", "?channel=a&event=a%0Ab", http.StatusBadRequest},
+		{"NoStream", "POST", "?channel=nobody", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, server.URL+"/.sse/publish"+tt.query, strings.NewReader("hello"))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+}
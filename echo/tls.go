@@ -0,0 +1,234 @@
+package echo
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTLSPort is the port used for the TLS listener when TLS_PORT is not set.
+	defaultTLSPort = "8443"
+
+	// selfSignedValidity is how long a generated self-signed certificate is valid for.
+	selfSignedValidity = 365 * 24 * time.Hour
+)
+
+// loadTLSConfig builds the configuration for the TLS listener. It returns nil
+// if TLS is not enabled.
+//
+// A certificate is loaded from TLS_CERT_FILE and TLS_KEY_FILE, or generated in
+// memory when TLS_SELF_SIGNED is true.
+func loadTLSConfig(c *config, logger *slog.Logger) (*tls.Config, error) {
+	var cert tls.Certificate
+
+	switch {
+	case c.TLSCertFile != "" || c.TLSKeyFile != "":
+		if c.TLSCertFile == "" || c.TLSKeyFile == "" {
+			return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		}
+
+		var err error
+		cert, err = tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load TLS certificate: %w", err)
+		}
+
+	case c.TLSSelfSigned:
+		hosts := []string{"localhost", "127.0.0.1", "::1"}
+		if hostname, err := os.Hostname(); err == nil {
+			hosts = append(hosts, hostname)
+		}
+		hosts = append(hosts, c.TLSSelfSignedHosts...)
+
+		var err error
+		cert, err = generateSelfSignedCertificate(hosts)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate self-signed certificate: %w", err)
+		}
+
+		logger.Info("generated self-signed certificate",
+			"hosts", strings.Join(hosts, ","),
+			"sha256_fingerprint", certificateFingerprint(cert.Certificate[0]),
+		)
+
+	default:
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if err := configureClientAuth(config, c.TLSClientAuth, c.TLSClientCAFile); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// configureClientAuth sets the client certificate policy of config to the
+// TLS_CLIENT_AUTH mode. The "verify" modes check client certificates against
+// the CA bundle in caFile.
+func configureClientAuth(config *tls.Config, mode, caFile string) error {
+	switch strings.ToLower(mode) {
+	case "", "none":
+		config.ClientAuth = tls.NoClientCert
+		return nil
+	case "request":
+		config.ClientAuth = tls.RequestClientCert
+		return nil
+	case "require":
+		config.ClientAuth = tls.RequireAnyClientCert
+		return nil
+	case "verify":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require-and-verify":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("unsupported TLS_CLIENT_AUTH mode %q", mode)
+	}
+
+	if caFile == "" {
+		return fmt.Errorf("TLS_CLIENT_CA_FILE must be set when TLS_CLIENT_AUTH is %q", mode)
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("unable to read client CA file: %w", err)
+	}
+
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	return nil
+}
+
+// generateSelfSignedCertificate creates an in-memory certificate that is valid
+// for the given DNS names and IP addresses.
+func generateSelfSignedCertificate(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "echo-server"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// certificateSANs returns the subject alternative names of cert, each prefixed
+// with its type.
+func certificateSANs(cert *x509.Certificate) []string {
+	var sans []string
+
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+
+	return sans
+}
+
+// writeTLS writes the details of a TLS connection to w, in the same style as
+// request headers.
+func writeTLS(w io.Writer, state *tls.ConnectionState) {
+	info := newEchoedTLS(state)
+
+	fmt.Fprintf(w, "TLS Version: %s\n", info.Version)
+	fmt.Fprintf(w, "TLS Cipher Suite: %s\n", info.CipherSuite)
+	if info.ServerName != "" {
+		fmt.Fprintf(w, "TLS Server Name: %s\n", info.ServerName)
+	}
+	if info.NegotiatedProtocol != "" {
+		fmt.Fprintf(w, "TLS Negotiated Protocol: %s\n", info.NegotiatedProtocol)
+	}
+	fmt.Fprintf(w, "TLS Resumed: %t\n", info.Resumed)
+
+	if len(info.ClientCertificates) > 0 {
+		fmt.Fprintf(w, "TLS Client Verified: %t\n", info.ClientVerified)
+	}
+
+	for i, cert := range info.ClientCertificates {
+		fmt.Fprintf(w, "TLS Client Certificate %d Subject: %s\n", i, cert.Subject)
+		fmt.Fprintf(w, "TLS Client Certificate %d Issuer: %s\n", i, cert.Issuer)
+		if len(cert.SANs) > 0 {
+			fmt.Fprintf(w, "TLS Client Certificate %d SANs: %s\n", i, strings.Join(cert.SANs, ", "))
+		}
+		fmt.Fprintf(w, "TLS Client Certificate %d SHA-256: %s\n", i, cert.SHA256Fingerprint)
+	}
+}
+
+// certificateFingerprint returns the SHA-256 fingerprint of a DER encoded
+// certificate as colon-separated hex.
+func certificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,250 @@
+package echo
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTLSTestServer starts the echo handler behind TLS using a generated
+// self-signed certificate, with HTTP/2 enabled.
+func newTLSTestServer(t *testing.T, clientAuth tls.ClientAuthType) *httptest.Server {
+	t.Helper()
+
+	cert, err := generateSelfSignedCertificate([]string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Failed to generate certificate: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(newHandler(testConfig(t)))
+	server.EnableHTTP2 = true
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuth,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestGenerateSelfSignedCertificate(t *testing.T) {
+	cert, err := generateSelfSignedCertificate([]string{"localhost", "example.test", "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Failed to generate certificate: %v", err)
+	}
+
+	for _, host := range []string{"localhost", "example.test", "127.0.0.1"} {
+		if err := cert.Leaf.VerifyHostname(host); err != nil {
+			t.Errorf("Certificate is not valid for %s: %v", host, err)
+		}
+	}
+
+	fingerprint := certificateFingerprint(cert.Certificate[0])
+	if len(fingerprint) != 32*3-1 {
+		t.Errorf("Unexpected fingerprint format: %s", fingerprint)
+	}
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		config, err := loadTLSConfig(testConfig(t), discardLogger())
+		if err != nil || config != nil {
+			t.Errorf("Expected TLS to be disabled, got %v (%v)", config, err)
+		}
+	})
+
+	t.Run("SelfSigned", func(t *testing.T) {
+		config, err := loadTLSConfig(testConfig(t, "TLS_SELF_SIGNED=true", "TLS_SELF_SIGNED_HOSTS=echo.test"), discardLogger())
+		if err != nil {
+			t.Fatalf("Failed to load TLS config: %v", err)
+		}
+		if len(config.Certificates) != 1 {
+			t.Fatalf("Expected one certificate, got %d", len(config.Certificates))
+		}
+		if err := config.Certificates[0].Leaf.VerifyHostname("echo.test"); err != nil {
+			t.Errorf("Certificate is not valid for TLS_SELF_SIGNED_HOSTS: %v", err)
+		}
+	})
+
+	t.Run("MissingKey", func(t *testing.T) {
+		cfg := testConfig(t)
+		cfg.TLSCertFile = "cert.pem"
+
+		if _, err := loadTLSConfig(cfg, discardLogger()); err == nil {
+			t.Errorf("Expected an error when TLS_KEY_FILE is not set")
+		}
+	})
+}
+
+func TestHTTPEchoOverTLS(t *testing.T) {
+	server := newTLSTestServer(t, tls.NoClientCert)
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Expected HTTP/2 to be negotiated, got %s", resp.Proto)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "WebSocket UI: https://") {
+		t.Errorf("Expected footer links to use https")
+	}
+}
+
+func TestHTTPEchoJSONOverTLS(t *testing.T) {
+	server := newTLSTestServer(t, tls.NoClientCert)
+
+	resp, err := server.Client().Get(server.URL + "/?format=json")
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var echo echoedRequest
+	if err := json.NewDecoder(resp.Body).Decode(&echo); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if echo.URL.Scheme != "https" {
+		t.Errorf("Expected scheme https, got %s", echo.URL.Scheme)
+	}
+	if echo.TLS == nil {
+		t.Fatalf("Expected TLS information")
+	}
+	if echo.TLS.NegotiatedProtocol != "h2" {
+		t.Errorf("Expected ALPN protocol h2, got %q", echo.TLS.NegotiatedProtocol)
+	}
+	if !strings.HasPrefix(echo.TLS.Version, "TLS 1.") {
+		t.Errorf("Unexpected TLS version %q", echo.TLS.Version)
+	}
+}
+
+func TestConfigureClientAuth(t *testing.T) {
+	cert, err := generateSelfSignedCertificate([]string{"client"})
+	if err != nil {
+		t.Fatalf("Failed to generate certificate: %v", err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		mode      string
+		caFile    string
+		expect    tls.ClientAuthType
+		expectErr bool
+	}{
+		{"Default", "", "", tls.NoClientCert, false},
+		{"Request", "request", "", tls.RequestClientCert, false},
+		{"Require", "REQUIRE", "", tls.RequireAnyClientCert, false},
+		{"Verify", "verify", caFile, tls.VerifyClientCertIfGiven, false},
+		{"RequireAndVerify", "require-and-verify", caFile, tls.RequireAndVerifyClientCert, false},
+		{"VerifyWithoutCA", "verify", "", 0, true},
+		{"Unknown", "sometimes", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &tls.Config{}
+			err := configureClientAuth(config, tt.mode, tt.caFile)
+
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if config.ClientAuth != tt.expect {
+				t.Errorf("Expected client auth %v, got %v", tt.expect, config.ClientAuth)
+			}
+			if tt.caFile != "" && config.ClientCAs == nil {
+				t.Errorf("Expected client CA pool to be loaded")
+			}
+		})
+	}
+}
+
+func TestHTTPEchoClientCertificate(t *testing.T) {
+	server := newTLSTestServer(t, tls.RequireAnyClientCert)
+
+	clientCert, err := generateSelfSignedCertificate([]string{"client.test", "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("Failed to generate client certificate: %v", err)
+	}
+
+	client := server.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{clientCert}
+
+	t.Run("JSON", func(t *testing.T) {
+		resp, err := client.Get(server.URL + "/?format=json")
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var echo echoedRequest
+		if err := json.NewDecoder(resp.Body).Decode(&echo); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		if echo.TLS == nil || len(echo.TLS.ClientCertificates) != 1 {
+			t.Fatalf("Expected one client certificate, got %+v", echo.TLS)
+		}
+
+		cert := echo.TLS.ClientCertificates[0]
+		if cert.Subject != "CN=echo-server" {
+			t.Errorf("Unexpected subject %q", cert.Subject)
+		}
+		if cert.SHA256Fingerprint != certificateFingerprint(clientCert.Certificate[0]) {
+			t.Errorf("Unexpected fingerprint %q", cert.SHA256Fingerprint)
+		}
+		if strings.Join(cert.SANs, ",") != "DNS:client.test,IP:10.0.0.1" {
+			t.Errorf("Unexpected SANs %v", cert.SANs)
+		}
+		if echo.TLS.ClientVerified {
+			t.Errorf("Expected client certificate to be unverified")
+		}
+	})
+
+	t.Run("Text", func(t *testing.T) {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		bodyStr := string(body)
+
+		for _, expect := range []string{
+			"TLS Version: TLS 1.3\n",
+			"TLS Negotiated Protocol: h2\n",
+			"TLS Client Certificate 0 Subject: CN=echo-server\n",
+			"TLS Client Certificate 0 SANs: DNS:client.test, IP:10.0.0.1\n",
+		} {
+			if !strings.Contains(bodyStr, expect) {
+				t.Errorf("Response does not contain %q:\n%s", expect, bodyStr)
+			}
+		}
+	})
+}
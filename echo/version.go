@@ -0,0 +1,53 @@
+package echo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Version is the release version of the server. echo-server sets it from its
+// own version, which is set at build time with -ldflags "-X main.version=v1.2.3".
+var Version = "dev"
+
+// versionInfo describes the build of the running binary.
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// readVersionInfo returns the version, and the commit and build time recorded
+// by the Go toolchain when the binary was built from a VCS checkout.
+func readVersionInfo() versionInfo {
+	info := versionInfo{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Commit = setting.Value
+			case "vcs.time":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+
+	return info
+}
+
+func (v versionInfo) String() string {
+	s := fmt.Sprintf("echo-server %s", v.Version)
+	if v.Commit != "" {
+		s += fmt.Sprintf(" (commit %s", v.Commit)
+		if v.BuildTime != "" {
+			s += fmt.Sprintf(", built %s", v.BuildTime)
+		}
+		s += ")"
+	}
+	return s + fmt.Sprintf(" %s", v.GoVersion)
+}
@@ -0,0 +1,312 @@
+package echo
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// jsonSubprotocol is the subprotocol that wraps each echoed frame in a JSON
+	// envelope.
+	jsonSubprotocol = "echo.json"
+
+	// deflateExtension is the extension response sent by gorilla/websocket
+	// when permessage-deflate is negotiated.
+	deflateExtension = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+	// defaultCompressionLevel is the flate compression level used when
+	// WEBSOCKET_COMPRESSION_LEVEL is not set.
+	defaultCompressionLevel = 1
+
+	// streamThreshold is the size above which a message is relayed back to
+	// the client as it is read, rather than being buffered in memory first.
+	streamThreshold = 64 * 1024
+
+	// maxBufferedMessageSize is the largest message that is held in memory to
+	// be echoed on a subprotocol that transforms whole messages. Larger
+	// messages close the connection with 1009 Message Too Big, even if
+	// WEBSOCKET_MAX_MESSAGE_SIZE allows them.
+	maxBufferedMessageSize = 1024 * 1024
+)
+
+// frameEncoder transforms an echoed frame for a specific subprotocol. seq is
+// the 1-based sequence number of the frame on its connection.
+type frameEncoder func(seq int, messageType int, data []byte) (int, []byte, error)
+
+// subprotocolEncoders maps the built-in subprotocols to their behavior. Frames
+// on connections using any other subprotocol are echoed unchanged.
+var subprotocolEncoders = map[string]frameEncoder{
+	jsonSubprotocol: encodeJSONEnvelope,
+}
+
+// echoedWebSocket describes the negotiated parameters of a WebSocket
+// connection.
+type echoedWebSocket struct {
+	Subprotocol      string         `json:"subprotocol,omitempty"`
+	Extensions       []string       `json:"extensions,omitempty"`
+	CompressionLevel int            `json:"compression_level,omitempty"`
+	MaxMessageSize   int64          `json:"max_message_size,omitempty"`
+	Limits           *limitSettings `json:"limits,omitempty"`
+}
+
+// websocketLimits controls the buffer sizes and maximum message size of
+// WebSocket connections. Zero values use the gorilla/websocket defaults, and
+// a zero MaxMessageSize allows messages of any size.
+type websocketLimits struct {
+	ReadBufferSize  int
+	WriteBufferSize int
+	MaxMessageSize  int64
+}
+
+// readMessageHead reads up to limit bytes of a message from r. If the message
+// did not end before limit bytes were read, the unread and possibly empty
+// remainder is returned as rest; otherwise rest is nil.
+func readMessageHead(r io.Reader, limit int64) (head []byte, rest io.Reader, err error) {
+	var buf bytes.Buffer
+
+	n, err := io.CopyN(&buf, r, limit)
+	if err == io.EOF {
+		return buf.Bytes(), nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if n == limit {
+		rest = r
+	}
+
+	return buf.Bytes(), rest, nil
+}
+
+// streamMessage echoes a message whose head has already been read, copying
+// the remainder from rest to the connection as it arrives. It returns the
+// total number of bytes echoed.
+func streamMessage(connection *websocket.Conn, messageType int, head []byte, rest io.Reader) (int64, error) {
+	w, err := connection.NextWriter(messageType)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := w.Write(head); err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(w, rest)
+	if err != nil {
+		// The message is deliberately left unterminated; the connection is
+		// about to be closed.
+		return int64(len(head)) + n, err
+	}
+
+	return int64(len(head)) + n, w.Close()
+}
+
+// errMessageTooBig is returned by readBuffered for a message that is too
+// large to buffer.
+var errMessageTooBig = fmt.Errorf("message larger than %d bytes cannot be buffered", maxBufferedMessageSize)
+
+// readBuffered reads the rest of a message from r, returning
+// errMessageTooBig if it is longer than limit.
+func readBuffered(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errMessageTooBig
+	}
+	return data, nil
+}
+
+// writePong answers a ping from the client.
+func writePong(connection *websocket.Conn, log *slog.Logger, appData string) error {
+	if err := connection.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(controlWriteWait)); err != nil {
+		return err
+	}
+	log.Debug("pong sent", "payload", appData)
+	return nil
+}
+
+// compressionSettings controls permessage-deflate for a connection.
+type compressionSettings struct {
+	Enabled bool
+	Level   int
+}
+
+// parseCompressionSettings reads the compression settings for req. The
+// server-wide defaults may be overridden per connection by the "compress" and
+// "compression_level" query parameters.
+func parseCompressionSettings(req *http.Request, defaults compressionSettings) (compressionSettings, error) {
+	settings := defaults
+
+	query := req.URL.Query()
+
+	if v := query.Get("compress"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return settings, fmt.Errorf("invalid compress %q: must be true or false", v)
+		}
+		settings.Enabled = enabled
+	}
+
+	if v := query.Get("compression_level"); v != "" {
+		level, err := strconv.Atoi(v)
+		if err != nil || !isValidCompressionLevel(level) {
+			return settings, fmt.Errorf("invalid compression_level %q: must be between %d and %d", v, flate.HuffmanOnly, flate.BestCompression)
+		}
+		settings.Level = level
+	}
+
+	return settings, nil
+}
+
+// isValidCompressionLevel returns true if level is accepted by
+// websocket.Conn.SetCompressionLevel.
+func isValidCompressionLevel(level int) bool {
+	return level >= flate.HuffmanOnly && level <= flate.BestCompression
+}
+
+// offersDeflate returns true if the client offered the permessage-deflate
+// extension.
+func offersDeflate(req *http.Request) bool {
+	for _, header := range req.Header.Values("Sec-Websocket-Extensions") {
+		for _, ext := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.TrimSpace(name) == "permessage-deflate" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// deflatedSize returns the size of data once compressed by permessage-deflate
+// at the given level, without context takeover.
+func deflatedSize(data []byte, level int) int {
+	var buf bytes.Buffer
+
+	fw, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return 0
+	}
+	fw.Write(data) // nolint:errcheck
+	fw.Flush()     // nolint:errcheck
+
+	// The trailing empty block written by Flush is stripped from each message.
+	return max(buf.Len()-4, 0)
+}
+
+// jsonEnvelope is the message sent for each echoed frame on the echo.json
+// subprotocol.
+type jsonEnvelope struct {
+	Seq       int       `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"`
+	Data      string    `json:"data"`
+	Base64    bool      `json:"base64,omitempty"`
+}
+
+// encodeJSONEnvelope wraps a frame in a jsonEnvelope. Binary frames, and text
+// frames that are not valid UTF-8, are base64 encoded.
+func encodeJSONEnvelope(seq int, messageType int, data []byte) (int, []byte, error) {
+	env := jsonEnvelope{
+		Seq:       seq,
+		Timestamp: time.Now().UTC(),
+		Type:      "text",
+		Data:      string(data),
+	}
+
+	if messageType == websocket.BinaryMessage {
+		env.Type = "binary"
+	}
+
+	if messageType == websocket.BinaryMessage || !utf8.Valid(data) {
+		env.Data = base64.StdEncoding.EncodeToString(data)
+		env.Base64 = true
+	}
+
+	out, err := json.Marshal(env)
+	return websocket.TextMessage, out, err
+}
+
+// selectSubprotocol returns the first subprotocol offered by the client that
+// is also supported by the server, or an empty string if there is none. A
+// supported subprotocol of "*" accepts whichever the client offers first.
+func selectSubprotocol(req *http.Request, supported []string) string {
+	for _, offered := range websocket.Subprotocols(req) {
+		for _, p := range supported {
+			if p == "*" || p == offered {
+				return offered
+			}
+		}
+	}
+
+	return ""
+}
+
+// websocketGreeting returns the first message sent on a new WebSocket
+// connection. It is a structured echo of the upgrade request if a structured
+// format was requested or the echo.json subprotocol was negotiated, otherwise
+// it names the server and the negotiated subprotocol and extensions.
+func websocketGreeting(req *http.Request, info *echoedWebSocket, servedBy *instanceIdentity) ([]byte, error) {
+	format := negotiateFormat(req)
+	if format == formatText && info.Subprotocol == jsonSubprotocol {
+		format = formatJSON
+	}
+
+	if format != formatText {
+		echo := newEchoedRequest(req)
+		echo.setServedBy(servedBy)
+		echo.WebSocket = info
+
+		return marshalEcho(format, echo, false)
+	}
+
+	var lines []string
+
+	if servedBy != nil {
+		lines = append(lines, fmt.Sprintf("Request served by %s", servedBy))
+	}
+
+	if info.Subprotocol != "" {
+		lines = append(lines, fmt.Sprintf("Subprotocol: %s", info.Subprotocol))
+	}
+
+	for _, ext := range info.Extensions {
+		lines = append(lines, fmt.Sprintf("Extension: %s", ext))
+	}
+
+	if info.MaxMessageSize > 0 {
+		lines = append(lines, fmt.Sprintf("Max message size: %d byte(s)", info.MaxMessageSize))
+	}
+
+	if limits := info.Limits; limits != nil {
+		if limits.MaxConnections > 0 {
+			lines = append(lines, fmt.Sprintf("Connection limit: %d", limits.MaxConnections))
+		}
+		if limits.MaxConnectionsPerIP > 0 {
+			lines = append(lines, fmt.Sprintf("Connection limit per IP: %d", limits.MaxConnectionsPerIP))
+		}
+		if limits.RateLimit > 0 {
+			lines = append(lines, fmt.Sprintf("Rate limit: %g request(s)/s", limits.RateLimit))
+		}
+		if limits.RateLimitPerIP > 0 {
+			lines = append(lines, fmt.Sprintf("Rate limit per IP: %g request(s)/s", limits.RateLimitPerIP))
+		}
+	}
+
+	return []byte(strings.Join(lines, "\n")), nil
+}
@@ -0,0 +1,479 @@
+package echo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialWebSocket connects to the echo handler served by server, offering the
+// given subprotocols, and returns the connection and the greeting message.
+func dialWebSocket(t *testing.T, server *httptest.Server, path string, subprotocols ...string) (*websocket.Conn, string) {
+	t.Helper()
+	return dialWebSocketWith(t, &websocket.Dialer{Subprotocols: subprotocols}, server, path)
+}
+
+// dialWebSocketWith connects to the echo handler served by server using
+// dialer, and returns the connection and the greeting message.
+func dialWebSocketWith(t *testing.T, dialer *websocket.Dialer, server *httptest.Server, path string) (*websocket.Conn, string) {
+	t.Helper()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + path
+
+	ws, _, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	_ = ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, greeting, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read greeting: %v", err)
+	}
+
+	return ws, string(greeting)
+}
+
+func TestSelectSubprotocol(t *testing.T) {
+	tests := []struct {
+		name      string
+		offered   string
+		supported []string
+		expect    string
+	}{
+		{"NoneOffered", "", []string{"echo.json"}, ""},
+		{"Supported", "chat, echo.json", []string{"echo.json"}, "echo.json"},
+		{"ClientOrder", "b, a", []string{"a", "b"}, "b"},
+		{"Unsupported", "chat", []string{"echo.json"}, ""},
+		{"Wildcard", "chat, echo.json", []string{"*"}, "chat"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.offered != "" {
+				req.Header.Set("Sec-WebSocket-Protocol", tt.offered)
+			}
+
+			if got := selectSubprotocol(req, tt.supported); got != tt.expect {
+				t.Errorf("Expected %q, got %q", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestWebSocketSubprotocolNegotiation(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    string
+		offer  []string
+		expect string
+	}{
+		{"DefaultUnsupported", "", []string{"chat"}, ""},
+		{"Configured", "chat, mqtt", []string{"mqtt", "chat"}, "mqtt"},
+		{"Wildcard", "*", []string{"graphql-ws", "chat"}, "graphql-ws"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(newHandler(testConfig(t, "WEBSOCKET_SUBPROTOCOLS="+tt.env)))
+			defer server.Close()
+
+			ws, greeting := dialWebSocket(t, server, "/", tt.offer...)
+
+			if got := ws.Subprotocol(); got != tt.expect {
+				t.Errorf("Expected subprotocol %q, got %q", tt.expect, got)
+			}
+
+			hasSubprotocol := strings.Contains(greeting, "Subprotocol: "+tt.expect)
+			if hasSubprotocol != (tt.expect != "") {
+				t.Errorf("Unexpected greeting %q", greeting)
+			}
+
+			// Frames on other subprotocols are echoed unchanged.
+			if err := ws.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+				t.Fatalf("Failed to send message: %v", err)
+			}
+			_, msg, err := ws.ReadMessage()
+			if err != nil || string(msg) != "hello" {
+				t.Errorf("Expected echo 'hello', got '%s' (%v)", msg, err)
+			}
+		})
+	}
+}
+
+func TestWebSocketJSONSubprotocol(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	ws, greeting := dialWebSocket(t, server, "/", "chat", jsonSubprotocol)
+
+	if ws.Subprotocol() != jsonSubprotocol {
+		t.Fatalf("Expected subprotocol %q, got %q", jsonSubprotocol, ws.Subprotocol())
+	}
+
+	var echo echoedRequest
+	if err := json.Unmarshal([]byte(greeting), &echo); err != nil {
+		t.Fatalf("Expected a JSON greeting, got %q: %v", greeting, err)
+	}
+	if echo.WebSocket == nil || echo.WebSocket.Subprotocol != jsonSubprotocol {
+		t.Errorf("Expected greeting to report the subprotocol, got %+v", echo.WebSocket)
+	}
+
+	frames := []struct {
+		messageType int
+		data        string
+	}{
+		{websocket.TextMessage, "first"},
+		{websocket.BinaryMessage, "\x00\x01\x02"},
+	}
+
+	for i, frame := range frames {
+		if err := ws.WriteMessage(frame.messageType, []byte(frame.data)); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+
+		messageType, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read envelope: %v", err)
+		}
+		if messageType != websocket.TextMessage {
+			t.Errorf("Expected envelope in a text frame, got type %d", messageType)
+		}
+
+		var env jsonEnvelope
+		if err := json.Unmarshal(msg, &env); err != nil {
+			t.Fatalf("Failed to decode envelope %q: %v", msg, err)
+		}
+
+		if env.Seq != i+1 {
+			t.Errorf("Expected sequence number %d, got %d", i+1, env.Seq)
+		}
+		if env.Timestamp.IsZero() {
+			t.Errorf("Expected a timestamp")
+		}
+
+		data := env.Data
+		if env.Base64 {
+			decoded, _ := base64.StdEncoding.DecodeString(env.Data)
+			data = string(decoded)
+		}
+		if data != frame.data {
+			t.Errorf("Expected data %q, got %q", frame.data, data)
+		}
+		if frame.messageType == websocket.BinaryMessage && (env.Type != "binary" || !env.Base64) {
+			t.Errorf("Expected base64 binary envelope, got %+v", env)
+		}
+	}
+}
+
+func TestWebSocketCompression(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           string
+		path          string
+		expectDeflate bool
+	}{
+		{"DefaultDisabled", "", "/", false},
+		{"EnabledByEnv", "true", "/", true},
+		{"DisabledByQuery", "true", "/?compress=false", false},
+		{"EnabledByQuery", "", "/?compress=true&compression_level=9", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(newHandler(testConfig(t, "WEBSOCKET_COMPRESSION="+tt.env)))
+			defer server.Close()
+
+			dialer := &websocket.Dialer{EnableCompression: true}
+			ws, greeting := dialWebSocketWith(t, dialer, server, tt.path)
+
+			hasExtension := strings.Contains(greeting, "Extension: permessage-deflate")
+			if hasExtension != tt.expectDeflate {
+				t.Errorf("Expected permessage-deflate=%v, got greeting %q", tt.expectDeflate, greeting)
+			}
+
+			message := strings.Repeat("compress me ", 1000)
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+				t.Fatalf("Failed to send message: %v", err)
+			}
+			_, msg, err := ws.ReadMessage()
+			if err != nil || string(msg) != message {
+				t.Errorf("Echo mismatch: got %d bytes (%v)", len(msg), err)
+			}
+		})
+	}
+}
+
+func TestWebSocketCompressionGreetingJSON(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	dialer := &websocket.Dialer{EnableCompression: true}
+	_, greeting := dialWebSocketWith(t, dialer, server, "/?format=json&compress=true&compression_level=5")
+
+	var echo echoedRequest
+	if err := json.Unmarshal([]byte(greeting), &echo); err != nil {
+		t.Fatalf("Failed to decode greeting: %v", err)
+	}
+
+	if echo.WebSocket == nil || len(echo.WebSocket.Extensions) != 1 || echo.WebSocket.Extensions[0] != deflateExtension {
+		t.Fatalf("Expected negotiated extensions in greeting, got %+v", echo.WebSocket)
+	}
+	if echo.WebSocket.CompressionLevel != 5 {
+		t.Errorf("Expected compression level 5, got %d", echo.WebSocket.CompressionLevel)
+	}
+}
+
+func TestWebSocketCompressionInvalidLevel(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/?compression_level=12"
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil {
+		t.Fatalf("Expected the handshake to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %v", resp)
+	}
+}
+
+func TestDeflatedSize(t *testing.T) {
+	data := []byte(strings.Repeat("a", 4096))
+
+	if n := deflatedSize(data, 9); n <= 0 || n >= len(data)/10 {
+		t.Errorf("Expected repetitive data to compress well, got %d bytes", n)
+	}
+	if n := deflatedSize(nil, 1); n < 0 {
+		t.Errorf("Expected a non-negative size for empty data, got %d", n)
+	}
+}
+
+func TestWebSocketLimitsConfig(t *testing.T) {
+	limits := testConfig(t, "WEBSOCKET_READ_BUFFER_SIZE=4096", "WEBSOCKET_MAX_MESSAGE_SIZE=1048576").WebSocketLimits
+
+	if limits.ReadBufferSize != 4096 {
+		t.Errorf("Expected read buffer size 4096, got %d", limits.ReadBufferSize)
+	}
+	if limits.WriteBufferSize != 0 {
+		t.Errorf("Expected default write buffer size, got %d", limits.WriteBufferSize)
+	}
+	if limits.MaxMessageSize != 1048576 {
+		t.Errorf("Expected max message size 1048576, got %d", limits.MaxMessageSize)
+	}
+
+	if _, err := loadConfig(nil, []string{"WEBSOCKET_WRITE_BUFFER_SIZE=invalid"}); err == nil {
+		t.Errorf("Expected an invalid write buffer size to be rejected")
+	}
+}
+
+func TestWebSocketMaxMessageSize(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "WEBSOCKET_MAX_MESSAGE_SIZE=1024")))
+	defer server.Close()
+
+	ws, greeting := dialWebSocket(t, server, "/")
+
+	if !strings.Contains(greeting, "Max message size: 1024 byte(s)") {
+		t.Errorf("Expected greeting to report the limit, got %q", greeting)
+	}
+
+	// Messages within the limit are echoed.
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 1024))); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if _, msg, err := ws.ReadMessage(); err != nil || len(msg) != 1024 {
+		t.Fatalf("Expected a 1024 byte echo, got %d bytes (%v)", len(msg), err)
+	}
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 1025))); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	_, _, err := ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("Expected close 1009, got %v", err)
+	}
+}
+
+func TestWebSocketStreamedEcho(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	ws, _ := dialWebSocket(t, server, "/")
+
+	messages := [][]byte{
+		bytes.Repeat([]byte{0, 1, 2, 3}, streamThreshold), // streamed
+		[]byte("short"), // buffered
+	}
+
+	for _, message := range messages {
+		if err := ws.WriteMessage(websocket.BinaryMessage, message); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+
+		messageType, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read echo: %v", err)
+		}
+		if messageType != websocket.BinaryMessage || !bytes.Equal(msg, message) {
+			t.Errorf("Echo mismatch: sent %d bytes, got %d bytes of type %d", len(message), len(msg), messageType)
+		}
+	}
+}
+
+func TestWebSocketStreamedEchoWithPing(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	for _, subprotocol := range []string{"", "echo.json"} {
+		var subprotocols []string
+		if subprotocol != "" {
+			subprotocols = append(subprotocols, subprotocol)
+		}
+		ws, _ := dialWebSocket(t, server, "/", subprotocols...)
+		defer ws.Close()
+
+		pongs := make(chan string, 1)
+		ws.SetPongHandler(func(appData string) error {
+			pongs <- appData
+			return nil
+		})
+
+		// Ping once the server has read more than the head of the message,
+		// while the rest is still to come.
+		w, err := ws.NextWriter(websocket.BinaryMessage)
+		if err != nil {
+			t.Fatalf("Failed to start message: %v", err)
+		}
+		w.Write(bytes.Repeat([]byte{1}, streamThreshold+8*1024)) // nolint:errcheck
+		if err := ws.WriteControl(websocket.PingMessage, []byte("mid-message"), time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("Failed to send ping: %v", err)
+		}
+		w.Write(bytes.Repeat([]byte{2}, 8*1024)) // nolint:errcheck
+		if err := w.Close(); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+
+		_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, msg, err := ws.ReadMessage(); err != nil || len(msg) < streamThreshold+16*1024 {
+			t.Fatalf("Expected the message to be echoed on %q, got %d bytes (%v)", subprotocol, len(msg), err)
+		}
+		if pong := <-pongs; pong != "mid-message" {
+			t.Errorf("Expected the ping to be answered, got %q", pong)
+		}
+	}
+}
+
+// sendSlowMessage starts a binary message longer than streamThreshold and
+// keeps adding to it slowly until stop is closed or a write fails.
+func sendSlowMessage(ws *websocket.Conn, stop <-chan struct{}) {
+	w, err := ws.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return
+	}
+	if _, err := w.Write(bytes.Repeat([]byte{1}, streamThreshold+8*1024)); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+		if _, err := w.Write(bytes.Repeat([]byte{2}, 8*1024)); err != nil {
+			return
+		}
+	}
+}
+
+// readUntilClose reads from ws until it is closed, returning the close error.
+func readUntilClose(t *testing.T, ws *websocket.Conn, timeout time.Duration) error {
+	t.Helper()
+
+	_ = ws.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			return err
+		}
+	}
+}
+
+func TestWebSocketStreamedEchoTimeout(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t, "CONNECTION_TIMEOUT_MINUTES=0.01"))) // 600ms
+	defer server.Close()
+
+	ws, _ := dialWebSocket(t, server, "/")
+	defer ws.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go sendSlowMessage(ws, stop)
+
+	start := time.Now()
+	err := readUntilClose(t, ws, 5*time.Second)
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("Expected the connection to be closed at the timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected the timeout to apply while a message is read, took %s", elapsed)
+	}
+}
+
+func TestWebSocketStreamedEchoShutdown(t *testing.T) {
+	handler := newHandler(testConfig(t))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ws, _ := dialWebSocket(t, server, "/", jsonSubprotocol)
+	defer ws.Close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go sendSlowMessage(ws, stop)
+
+	time.Sleep(200 * time.Millisecond)
+	handler.shutdown()
+
+	if err := readUntilClose(t, ws, 3*time.Second); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("Expected the connection to be closed for shutdown, got %v", err)
+	}
+}
+
+func TestWebSocketBufferedMessageTooBig(t *testing.T) {
+	server := httptest.NewServer(newHandler(testConfig(t)))
+	defer server.Close()
+
+	ws, _ := dialWebSocket(t, server, "/", jsonSubprotocol)
+	defer ws.Close()
+
+	go ws.WriteMessage(websocket.BinaryMessage, make([]byte, maxBufferedMessageSize+1)) // nolint:errcheck
+
+	if err := readUntilClose(t, ws, 5*time.Second); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("Expected close 1009, got %v", err)
+	}
+}
+
+func TestReadMessageHead(t *testing.T) {
+	head, rest, err := readMessageHead(strings.NewReader("hello"), 10)
+	if err != nil || string(head) != "hello" || rest != nil {
+		t.Errorf("Expected whole message with no remainder, got %q, %v (%v)", head, rest, err)
+	}
+
+	head, rest, err = readMessageHead(strings.NewReader("hello world"), 5)
+	if err != nil || string(head) != "hello" || rest == nil {
+		t.Fatalf("Expected a head with a remainder, got %q, %v (%v)", head, rest, err)
+	}
+	if remainder, _ := io.ReadAll(rest); string(remainder) != " world" {
+		t.Errorf("Expected remainder ' world', got %q", remainder)
+	}
+}